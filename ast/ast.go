@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"math/big"
 	"strings"
 
 	"bariq/token"
@@ -11,6 +12,8 @@ type Node interface {
 	// used only in debugging and testing
 	TokenLiteral() string
 	String() string
+	// where the node starts in the source
+	Pos() token.Position
 }
 type Stmt interface {
 	Node
@@ -39,6 +42,13 @@ func (p *Program) String() string {
 	return out.String()
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Stmts) > 0 {
+		return p.Stmts[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) TokenLiteral() string {
 	if len(p.Stmts) > 0 {
 		return p.Stmts[0].TokenLiteral()
@@ -54,6 +64,7 @@ type ExprStmt struct {
 
 func (*ExprStmt) statementNode()          {}
 func (es *ExprStmt) TokenLiteral() string { return es.Token.Literal }
+func (es *ExprStmt) Pos() token.Position  { return es.Token.Pos }
 
 func (es *ExprStmt) String() string {
 	if es.Expr != nil {
@@ -78,11 +89,34 @@ func (ls *ReturnStmt) String() string {
 }
 func (*ReturnStmt) statementNode()          {}
 func (rs *ReturnStmt) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStmt) Pos() token.Position  { return rs.Token.Pos }
+
+// BreakStmt leaves the innermost loop
+type BreakStmt struct {
+	Token token.Token // break
+}
+
+func (bs *BreakStmt) String() string       { return "break;" }
+func (*BreakStmt) statementNode()          {}
+func (bs *BreakStmt) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStmt) Pos() token.Position  { return bs.Token.Pos }
+
+// ContinueStmt goes on with the next iteration of the innermost loop
+type ContinueStmt struct {
+	Token token.Token // continue
+}
+
+func (cs *ContinueStmt) String() string       { return "continue;" }
+func (*ContinueStmt) statementNode()          {}
+func (cs *ContinueStmt) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStmt) Pos() token.Position  { return cs.Token.Pos }
 
 type LetStmt struct {
-	Token token.Token // LET
+	Token token.Token // LET or CONST
 	Name  *Ident
 	Value Expr
+	// declared with const, the binding can't be changed
+	Const bool
 }
 
 func (ls *LetStmt) String() string {
@@ -98,6 +132,7 @@ func (ls *LetStmt) String() string {
 }
 func (*LetStmt) statementNode()          {}
 func (ls *LetStmt) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStmt) Pos() token.Position  { return ls.Token.Pos }
 
 type Ident struct {
 	Token token.Token // IDENT
@@ -109,6 +144,7 @@ func (i *Ident) String() string {
 	return i.Value
 }
 func (id *Ident) TokenLiteral() string { return id.Token.Literal }
+func (id *Ident) Pos() token.Position  { return id.Token.Pos }
 
 type IndexExpr struct {
 	Token token.Token
@@ -118,16 +154,36 @@ type IndexExpr struct {
 
 func (ie *IndexExpr) expressionNode()      {}
 func (ie *IndexExpr) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpr) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IndexExpr) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
 	out.WriteString(ie.Left.String())
+	// a.b is parsed as an index with the name as a string
+	if ie.Token.Type == token.DOT {
+		out.WriteString(".")
+		out.WriteString(ie.Index.String())
+		out.WriteString(")")
+		return out.String()
+	}
 	out.WriteString("[")
 	out.WriteString(ie.Index.String())
 	out.WriteString("])")
 	return out.String()
 }
 
+type ImportExpr struct {
+	Token token.Token // import
+	Path  string
+}
+
+func (ie *ImportExpr) expressionNode()      {}
+func (ie *ImportExpr) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpr) Pos() token.Position  { return ie.Token.Pos }
+func (ie *ImportExpr) String() string {
+	return ie.TokenLiteral() + " \"" + ie.Path + "\""
+}
+
 type HashLiteral struct {
 	Token token.Token //{
 	Pairs map[Expr]Expr
@@ -135,6 +191,7 @@ type HashLiteral struct {
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
@@ -154,6 +211,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elmnts := []string{}
@@ -174,17 +232,31 @@ type StringLiteral struct {
 func (s *StringLiteral) expressionNode()      {}
 func (s *StringLiteral) String() string       { return s.Token.Literal }
 func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
+func (s *StringLiteral) Pos() token.Position  { return s.Token.Pos }
 
 type IntLiteral struct {
 	Token token.Token
 	// now you know why using value along with
 	// token literal
 	Value int64
+	// set instead of Value when it doesn't fit in an int64
+	Big *big.Int
 }
 
 func (i *IntLiteral) expressionNode()       {}
 func (i *IntLiteral) String() string        { return i.Token.Literal }
 func (id *IntLiteral) TokenLiteral() string { return id.Token.Literal }
+func (id *IntLiteral) Pos() token.Position  { return id.Token.Pos }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (f *FloatLiteral) expressionNode()      {}
+func (f *FloatLiteral) String() string       { return f.Token.Literal }
+func (f *FloatLiteral) TokenLiteral() string { return f.Token.Literal }
+func (f *FloatLiteral) Pos() token.Position  { return f.Token.Pos }
 
 type PrefixExpr struct {
 	Token    token.Token // prefix token , ex: !
@@ -202,6 +274,7 @@ func (pe *PrefixExpr) String() string {
 	return out.String()
 }
 func (pe *PrefixExpr) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpr) Pos() token.Position  { return pe.Token.Pos }
 
 type InfixExpr struct {
 	Token    token.Token // prefix token , ex: !
@@ -221,6 +294,29 @@ func (ie *InfixExpr) String() string {
 	return out.String()
 }
 func (ie *InfixExpr) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpr) Pos() token.Position  { return ie.Token.Pos }
+
+// AssignExpr updates the binding of an Ident or the element of an
+// IndexExpr, Operator is = or a compound one like +=
+type AssignExpr struct {
+	Token    token.Token // the operator token
+	Target   Expr
+	Operator string
+	Value    Expr
+}
+
+func (ae *AssignExpr) expressionNode()      {}
+func (ae *AssignExpr) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpr) Pos() token.Position  { return ae.Token.Pos }
+func (ae *AssignExpr) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+	return out.String()
+}
 
 type Boolean struct {
 	Token token.Token
@@ -232,6 +328,7 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) String() string       { return b.Token.Literal }
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 
 type IfExpr struct {
 	Token       token.Token
@@ -242,6 +339,7 @@ type IfExpr struct {
 
 func (ie *IfExpr) expressionNode()      {}
 func (ie *IfExpr) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpr) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpr) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -262,6 +360,7 @@ type BlockStmt struct {
 
 func (bs *BlockStmt) expressionNode()      {}
 func (bs *BlockStmt) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStmt) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStmt) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Stmts {
@@ -277,6 +376,7 @@ type YieldExpr struct {
 
 func (ye *YieldExpr) expressionNode()      {}
 func (ye *YieldExpr) TokenLiteral() string { return ye.Token.Literal }
+func (ye *YieldExpr) Pos() token.Position  { return ye.Token.Pos }
 func (ye *YieldExpr) String() string {
 	var out bytes.Buffer
 	out.WriteString(ye.TokenLiteral())
@@ -292,6 +392,7 @@ type AwaitExpr struct {
 
 func (a *AwaitExpr) expressionNode()      {}
 func (a *AwaitExpr) TokenLiteral() string { return a.Token.Literal }
+func (a *AwaitExpr) Pos() token.Position  { return a.Token.Pos }
 func (a *AwaitExpr) String() string {
 	var out bytes.Buffer
 	out.WriteString(a.TokenLiteral())
@@ -301,6 +402,115 @@ func (a *AwaitExpr) String() string {
 	return out.String()
 }
 
+// WhileExpr runs Body as long as Condition is truthy
+type WhileExpr struct {
+	Token     token.Token // while
+	Condition Expr
+	Body      *BlockStmt
+}
+
+func (we *WhileExpr) expressionNode()      {}
+func (we *WhileExpr) TokenLiteral() string { return we.Token.Literal }
+func (we *WhileExpr) Pos() token.Position  { return we.Token.Pos }
+func (we *WhileExpr) String() string {
+	return "while (" + we.Condition.String() + ") " + we.Body.String()
+}
+
+// ForInExpr runs Body with Name bound to each value of Iterable and
+// Key, if given, to its index or key. Await is set by `for await`,
+// awaiting each value of an async generator.
+type ForInExpr struct {
+	Token    token.Token // for
+	Await    bool
+	Key      *Ident
+	Name     *Ident
+	Iterable Expr
+	Body     *BlockStmt
+}
+
+func (fe *ForInExpr) expressionNode()      {}
+func (fe *ForInExpr) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForInExpr) Pos() token.Position  { return fe.Token.Pos }
+func (fe *ForInExpr) String() string {
+	var out bytes.Buffer
+	out.WriteString("for ")
+	if fe.Await {
+		out.WriteString("await ")
+	}
+	out.WriteString("(")
+	if fe.Key != nil {
+		out.WriteString(fe.Key.String())
+		out.WriteString(", ")
+	}
+	out.WriteString(fe.Name.String())
+	out.WriteString(" in ")
+	out.WriteString(fe.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fe.Body.String())
+	return out.String()
+}
+
+// SelectExpr runs the first of its cases that can proceed,
+// or Default if none can right away and Default is set
+type SelectExpr struct {
+	Token   token.Token // select
+	Cases   []*SelectCase
+	Default *BlockStmt
+}
+
+func (se *SelectExpr) expressionNode()      {}
+func (se *SelectExpr) TokenLiteral() string { return se.Token.Literal }
+func (se *SelectExpr) Pos() token.Position  { return se.Token.Pos }
+func (se *SelectExpr) String() string {
+	var out bytes.Buffer
+	cases := []string{}
+	for _, c := range se.Cases {
+		cases = append(cases, c.String())
+	}
+	if se.Default != nil {
+		cases = append(cases, "default "+se.Default.String())
+	}
+	out.WriteString("select {")
+	out.WriteString(strings.Join(cases, " "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// SelectCase is a case of a select, Kind is recv, send or timeout
+type SelectCase struct {
+	Token token.Token // case
+	Kind  string
+	Args  []Expr
+	// the names a recv binds to the value received and to
+	// whether the channel is still open, nil if not given
+	Value *Ident
+	Ok    *Ident
+	Body  *BlockStmt
+}
+
+func (sc *SelectCase) String() string {
+	var out bytes.Buffer
+	out.WriteString("case ")
+	if sc.Value != nil {
+		out.WriteString(sc.Value.String())
+		if sc.Ok != nil {
+			out.WriteString(", ")
+			out.WriteString(sc.Ok.String())
+		}
+		out.WriteString(" = ")
+	}
+	args := []string{}
+	for _, a := range sc.Args {
+		args = append(args, a.String())
+	}
+	out.WriteString(sc.Kind)
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(") ")
+	out.WriteString(sc.Body.String())
+	return out.String()
+}
+
 type FunctionLiteral struct {
 	Token      token.Token // function
 	Parameters []*Ident
@@ -311,6 +521,7 @@ type FunctionLiteral struct {
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -333,6 +544,7 @@ type CallExpr struct {
 
 func (ce *CallExpr) expressionNode()      {}
 func (ce *CallExpr) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpr) Pos() token.Position  { return ce.Token.Pos }
 func (ce *CallExpr) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -345,3 +557,26 @@ func (ce *CallExpr) String() string {
 	out.WriteString(")")
 	return out.String()
 }
+
+type MacroLiteral struct {
+	Token      token.Token // macro
+	Parameters []*Ident
+	Body       *BlockStmt
+}
+
+func (ml *MacroLiteral) expressionNode()      {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.Position  { return ml.Token.Pos }
+func (ml *MacroLiteral) String() string {
+	var out bytes.Buffer
+	params := []string{}
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString(ml.Body.String())
+	return out.String()
+}
@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"strings"
 	"testing"
 
 	"bariq/token"
@@ -39,3 +40,111 @@ func TestString(t *testing.T) {
 		)
 	}
 }
+
+func TestModify(t *testing.T) {
+	one := func() Expr {
+		return &IntLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1}
+	}
+	two := func() Expr {
+		return &IntLiteral{Token: token.Token{Type: token.INT, Literal: "2"}, Value: 2}
+	}
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntLiteral)
+		if !ok || integer.Value != 1 {
+			return node
+		}
+		return two()
+	}
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{one(), two()},
+		{
+			&Program{Stmts: []Stmt{&ExprStmt{Expr: one()}}},
+			&Program{Stmts: []Stmt{&ExprStmt{Expr: two()}}},
+		},
+		{&InfixExpr{Left: one(), Operator: "+", Right: two()}, &InfixExpr{Left: two(), Operator: "+", Right: two()}},
+		{&PrefixExpr{Operator: "-", Right: one()}, &PrefixExpr{Operator: "-", Right: two()}},
+		{&IndexExpr{Left: one(), Index: one()}, &IndexExpr{Left: two(), Index: two()}},
+		{
+			&IfExpr{
+				Condition:   one(),
+				Consequence: &BlockStmt{Stmts: []Stmt{&ExprStmt{Expr: one()}}},
+				Alternative: &BlockStmt{Stmts: []Stmt{&ExprStmt{Expr: one()}}},
+			},
+			&IfExpr{
+				Condition:   two(),
+				Consequence: &BlockStmt{Stmts: []Stmt{&ExprStmt{Expr: two()}}},
+				Alternative: &BlockStmt{Stmts: []Stmt{&ExprStmt{Expr: two()}}},
+			},
+		},
+		{&ReturnStmt{Value: one()}, &ReturnStmt{Value: two()}},
+		{&LetStmt{Name: &Ident{Value: "a"}, Value: one()}, &LetStmt{Name: &Ident{Value: "a"}, Value: two()}},
+		{
+			&FunctionLiteral{Body: &BlockStmt{Stmts: []Stmt{&ExprStmt{Expr: one()}}}},
+			&FunctionLiteral{Body: &BlockStmt{Stmts: []Stmt{&ExprStmt{Expr: two()}}}},
+		},
+		{&ArrayLiteral{Elmnts: []Expr{one(), one()}}, &ArrayLiteral{Elmnts: []Expr{two(), two()}}},
+		{&CallExpr{Function: &Ident{Value: "f"}, Args: []Expr{one()}}, &CallExpr{Function: &Ident{Value: "f"}, Args: []Expr{two()}}},
+		{
+			&ForInExpr{Name: &Ident{Value: "x"}, Iterable: one(), Body: &BlockStmt{Stmts: []Stmt{&ExprStmt{Expr: one()}}}},
+			&ForInExpr{Name: &Ident{Value: "x"}, Iterable: two(), Body: &BlockStmt{Stmts: []Stmt{&ExprStmt{Expr: two()}}}},
+		},
+	}
+	for _, tt := range tests {
+		before := tt.input.String()
+		modified := Modify(tt.input, turnOneIntoTwo)
+		if modified.String() != tt.expected.String() {
+			t.Errorf("not equal, got %q, want %q", modified.String(), tt.expected.String())
+		}
+		if tt.input.String() != before {
+			t.Errorf("input was changed, got %q, want %q", tt.input.String(), before)
+		}
+	}
+
+	hash := &HashLiteral{Pairs: map[Expr]Expr{one(): one()}}
+	modified := Modify(hash, turnOneIntoTwo).(*HashLiteral)
+	for k, v := range modified.Pairs {
+		if k.(*IntLiteral).Value != 2 || v.(*IntLiteral).Value != 2 {
+			t.Errorf("hash pair was not modified, got %s: %s", k, v)
+		}
+	}
+
+	// binders are walked too
+	rename := func(node Node) Node {
+		if ident, ok := node.(*Ident); ok {
+			return &Ident{Token: ident.Token, Value: ident.Value + "2"}
+		}
+		return node
+	}
+	loop := &ForInExpr{Key: &Ident{Value: "i"}, Name: &Ident{Value: "x"}, Iterable: &Ident{Value: "xs"}, Body: &BlockStmt{}}
+	if got := Modify(loop, rename).(*ForInExpr); got.Key.Value != "i2" || got.Name.Value != "x2" {
+		t.Errorf("loop names were not modified, got %s and %s", got.Key, got.Name)
+	}
+	sel := &SelectExpr{Cases: []*SelectCase{{Value: &Ident{Value: "v"}, Ok: &Ident{Value: "ok"}, Body: &BlockStmt{}}}}
+	if got := Modify(sel, rename).(*SelectExpr).Cases[0]; got.Value.Value != "v2" || got.Ok.Value != "ok2" {
+		t.Errorf("select case names were not modified, got %s and %s", got.Value, got.Ok)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	ident := func(name string) *Ident { return &Ident{Value: name} }
+	body := &BlockStmt{Stmts: []Stmt{
+		&LetStmt{Name: ident("a"), Value: &InfixExpr{Left: ident("b"), Operator: "+", Right: ident("c")}},
+		&ExprStmt{Expr: &FunctionLiteral{Parameters: []*Ident{ident("d")}, Body: &BlockStmt{Stmts: []Stmt{&ExprStmt{Expr: ident("e")}}}}},
+		&ExprStmt{Expr: &ForInExpr{Name: ident("f"), Iterable: ident("g"), Body: &BlockStmt{}}},
+	}}
+	names := []string{}
+	Walk(body, func(node Node) bool {
+		if ident, ok := node.(*Ident); ok {
+			names = append(names, ident.Value)
+		}
+		// the function bodies are skipped
+		_, isFn := node.(*FunctionLiteral)
+		return !isFn
+	})
+	if got := strings.Join(names, " "); got != "a b c f g" {
+		t.Errorf("wrong nodes walked, got %q", got)
+	}
+}
@@ -0,0 +1,160 @@
+package ast
+
+type ModifierFunc func(Node) Node
+
+// Modify walks node depth first, replacing every node with the result of
+// modifier called on it after its children were modified.
+// nodes on the way are copied so the input tree is left untouched,
+// ex: a macro body can be expanded many times.
+func Modify(node Node, modifier ModifierFunc) Node {
+	if node == nil {
+		return nil
+	}
+	switch node := node.(type) {
+	case *Program:
+		n := *node
+		n.Stmts = modifyStmts(node.Stmts, modifier)
+		return modifier(&n)
+	case *ExprStmt:
+		n := *node
+		n.Expr, _ = Modify(node.Expr, modifier).(Expr)
+		return modifier(&n)
+	case *BlockStmt:
+		if node == nil {
+			return node
+		}
+		n := *node
+		n.Stmts = modifyStmts(node.Stmts, modifier)
+		return modifier(&n)
+	case *ReturnStmt:
+		n := *node
+		n.Value, _ = Modify(node.Value, modifier).(Expr)
+		return modifier(&n)
+	case *LetStmt:
+		n := *node
+		n.Name, _ = Modify(node.Name, modifier).(*Ident)
+		n.Value, _ = Modify(node.Value, modifier).(Expr)
+		return modifier(&n)
+	case *InfixExpr:
+		n := *node
+		n.Left, _ = Modify(node.Left, modifier).(Expr)
+		n.Right, _ = Modify(node.Right, modifier).(Expr)
+		return modifier(&n)
+	case *AssignExpr:
+		n := *node
+		n.Target, _ = Modify(node.Target, modifier).(Expr)
+		n.Value, _ = Modify(node.Value, modifier).(Expr)
+		return modifier(&n)
+	case *PrefixExpr:
+		n := *node
+		n.Right, _ = Modify(node.Right, modifier).(Expr)
+		return modifier(&n)
+	case *IndexExpr:
+		n := *node
+		n.Left, _ = Modify(node.Left, modifier).(Expr)
+		n.Index, _ = Modify(node.Index, modifier).(Expr)
+		return modifier(&n)
+	case *IfExpr:
+		n := *node
+		n.Condition, _ = Modify(node.Condition, modifier).(Expr)
+		n.Consequence, _ = Modify(node.Consequence, modifier).(*BlockStmt)
+		if node.Alternative != nil {
+			n.Alternative, _ = Modify(node.Alternative, modifier).(*BlockStmt)
+		}
+		return modifier(&n)
+	case *FunctionLiteral:
+		n := *node
+		n.Parameters = modifyParams(node.Parameters, modifier)
+		n.Body, _ = Modify(node.Body, modifier).(*BlockStmt)
+		return modifier(&n)
+	case *MacroLiteral:
+		n := *node
+		n.Parameters = modifyParams(node.Parameters, modifier)
+		n.Body, _ = Modify(node.Body, modifier).(*BlockStmt)
+		return modifier(&n)
+	case *CallExpr:
+		n := *node
+		n.Function, _ = Modify(node.Function, modifier).(Expr)
+		n.Args = modifyExprs(node.Args, modifier)
+		return modifier(&n)
+	case *ArrayLiteral:
+		n := *node
+		n.Elmnts = modifyExprs(node.Elmnts, modifier)
+		return modifier(&n)
+	case *HashLiteral:
+		n := *node
+		n.Pairs = make(map[Expr]Expr, len(node.Pairs))
+		for k, v := range node.Pairs {
+			newK, _ := Modify(k, modifier).(Expr)
+			newV, _ := Modify(v, modifier).(Expr)
+			n.Pairs[newK] = newV
+		}
+		return modifier(&n)
+	case *YieldExpr:
+		n := *node
+		n.Arg, _ = Modify(node.Arg, modifier).(Expr)
+		return modifier(&n)
+	case *AwaitExpr:
+		n := *node
+		n.Arg, _ = Modify(node.Arg, modifier).(Expr)
+		return modifier(&n)
+	case *WhileExpr:
+		n := *node
+		n.Condition, _ = Modify(node.Condition, modifier).(Expr)
+		n.Body, _ = Modify(node.Body, modifier).(*BlockStmt)
+		return modifier(&n)
+	case *ForInExpr:
+		n := *node
+		if node.Key != nil {
+			n.Key, _ = Modify(node.Key, modifier).(*Ident)
+		}
+		n.Name, _ = Modify(node.Name, modifier).(*Ident)
+		n.Iterable, _ = Modify(node.Iterable, modifier).(Expr)
+		n.Body, _ = Modify(node.Body, modifier).(*BlockStmt)
+		return modifier(&n)
+	case *SelectExpr:
+		n := *node
+		n.Cases = make([]*SelectCase, len(node.Cases))
+		for i, c := range node.Cases {
+			nc := *c
+			if c.Value != nil {
+				nc.Value, _ = Modify(c.Value, modifier).(*Ident)
+			}
+			if c.Ok != nil {
+				nc.Ok, _ = Modify(c.Ok, modifier).(*Ident)
+			}
+			nc.Args = modifyExprs(c.Args, modifier)
+			nc.Body, _ = Modify(c.Body, modifier).(*BlockStmt)
+			n.Cases[i] = &nc
+		}
+		if node.Default != nil {
+			n.Default, _ = Modify(node.Default, modifier).(*BlockStmt)
+		}
+		return modifier(&n)
+	}
+	return modifier(node)
+}
+
+func modifyStmts(stmts []Stmt, modifier ModifierFunc) []Stmt {
+	res := make([]Stmt, len(stmts))
+	for i, s := range stmts {
+		res[i], _ = Modify(s, modifier).(Stmt)
+	}
+	return res
+}
+
+func modifyExprs(exprs []Expr, modifier ModifierFunc) []Expr {
+	res := make([]Expr, len(exprs))
+	for i, e := range exprs {
+		res[i], _ = Modify(e, modifier).(Expr)
+	}
+	return res
+}
+
+func modifyParams(params []*Ident, modifier ModifierFunc) []*Ident {
+	res := make([]*Ident, len(params))
+	for i, p := range params {
+		res[i], _ = Modify(p, modifier).(*Ident)
+	}
+	return res
+}
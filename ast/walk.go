@@ -0,0 +1,145 @@
+package ast
+
+// Walk calls fn on node then on its children depth first,
+// the children of a node are skipped when fn returns false
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	switch node := node.(type) {
+	case *Program:
+		walkStmts(node.Stmts, fn)
+	case *ExprStmt:
+		walkExpr(node.Expr, fn)
+	case *BlockStmt:
+		walkStmts(node.Stmts, fn)
+	case *ReturnStmt:
+		walkExpr(node.Value, fn)
+	case *LetStmt:
+		Walk(node.Name, fn)
+		walkExpr(node.Value, fn)
+	case *InfixExpr:
+		walkExpr(node.Left, fn)
+		walkExpr(node.Right, fn)
+	case *AssignExpr:
+		walkExpr(node.Target, fn)
+		walkExpr(node.Value, fn)
+	case *PrefixExpr:
+		walkExpr(node.Right, fn)
+	case *IndexExpr:
+		walkExpr(node.Left, fn)
+		walkExpr(node.Index, fn)
+	case *IfExpr:
+		walkExpr(node.Condition, fn)
+		walkBlock(node.Consequence, fn)
+		walkBlock(node.Alternative, fn)
+	case *FunctionLiteral:
+		walkIdents(node.Parameters, fn)
+		walkBlock(node.Body, fn)
+	case *MacroLiteral:
+		walkIdents(node.Parameters, fn)
+		walkBlock(node.Body, fn)
+	case *CallExpr:
+		walkExpr(node.Function, fn)
+		walkExprs(node.Args, fn)
+	case *ArrayLiteral:
+		walkExprs(node.Elmnts, fn)
+	case *HashLiteral:
+		for k, v := range node.Pairs {
+			walkExpr(k, fn)
+			walkExpr(v, fn)
+		}
+	case *YieldExpr:
+		walkExpr(node.Arg, fn)
+	case *AwaitExpr:
+		walkExpr(node.Arg, fn)
+	case *WhileExpr:
+		walkExpr(node.Condition, fn)
+		walkBlock(node.Body, fn)
+	case *ForInExpr:
+		if node.Key != nil {
+			Walk(node.Key, fn)
+		}
+		Walk(node.Name, fn)
+		walkExpr(node.Iterable, fn)
+		walkBlock(node.Body, fn)
+	case *SelectExpr:
+		for _, c := range node.Cases {
+			if c.Value != nil {
+				Walk(c.Value, fn)
+			}
+			if c.Ok != nil {
+				Walk(c.Ok, fn)
+			}
+			walkExprs(c.Args, fn)
+			walkBlock(c.Body, fn)
+		}
+		walkBlock(node.Default, fn)
+	}
+}
+
+// Binders returns the names node binds with let, for or select,
+// the names bound in the functions it defines are theirs, ex: the
+// names a function body binds in the env of its calls
+func Binders(node Node) []*Ident {
+	names := []*Ident{}
+	Walk(node, func(n Node) bool {
+		switch n := n.(type) {
+		case *FunctionLiteral, *MacroLiteral:
+			return false
+		case *LetStmt:
+			names = append(names, n.Name)
+		case *ForInExpr:
+			if n.Key != nil {
+				names = append(names, n.Key)
+			}
+			names = append(names, n.Name)
+		case *SelectExpr:
+			for _, c := range n.Cases {
+				if c.Value != nil {
+					names = append(names, c.Value)
+				}
+				if c.Ok != nil {
+					names = append(names, c.Ok)
+				}
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// the helpers below skip nil children, which would
+// be non nil interfaces holding nil pointers otherwise
+
+func walkExpr(e Expr, fn func(Node) bool) {
+	if e != nil {
+		Walk(e, fn)
+	}
+}
+
+func walkBlock(b *BlockStmt, fn func(Node) bool) {
+	if b != nil {
+		Walk(b, fn)
+	}
+}
+
+func walkStmts(stmts []Stmt, fn func(Node) bool) {
+	for _, s := range stmts {
+		if s != nil {
+			Walk(s, fn)
+		}
+	}
+}
+
+func walkExprs(exprs []Expr, fn func(Node) bool) {
+	for _, e := range exprs {
+		walkExpr(e, fn)
+	}
+}
+
+func walkIdents(idents []*Ident, fn func(Node) bool) {
+	for _, id := range idents {
+		Walk(id, fn)
+	}
+}
@@ -0,0 +1,136 @@
+// Package bariq embeds the interpreter in Go programs.
+//
+//	in := bariq.New()
+//	in.RegisterBuiltin("double", func(ctx *object.Context, args ...object.Object) object.Object {
+//		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+//	})
+//	in.Eval(`let f = fn(x) { double(x) + 1 };`)
+//	res, err := in.Call("f", &object.Integer{Value: 4}) // 9
+package bariq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"bariq/evaluator"
+	"bariq/lexer"
+	"bariq/object"
+	"bariq/parser"
+	"bariq/sched"
+)
+
+// Interpreter runs scripts in its own root env with its own
+// builtins, bindings made by a call to Eval are kept for the
+// next ones. It must not be used by several goroutines at once.
+type Interpreter struct {
+	ctx    *object.Context
+	env    *object.Env
+	macros *object.Env
+}
+
+// New returns an interpreter with the default builtins and
+// its own scheduler, using the standard streams of the process
+func New() *Interpreter {
+	ctx := object.NewContext()
+	ctx.Builtins = evaluator.Builtins()
+	ctx.Sched = sched.New(sched.DefaultWorkers)
+	return &Interpreter{
+		ctx:    ctx,
+		env:    object.NewEnv(),
+		macros: object.NewEnv(),
+	}
+}
+
+// Context is the context scripts run with,
+// its streams, clock and limits can be replaced
+func (in *Interpreter) Context() *object.Context { return in.ctx }
+
+// SetLimits bounds what each call to Eval or Call can use
+func (in *Interpreter) SetLimits(limits object.Limits) { in.ctx.Limits = limits }
+
+// SetTracer sends the events of the scripts to t,
+// ex: an evaluator.WriterTracer, nil disables tracing
+func (in *Interpreter) SetTracer(t object.Tracer) { in.ctx.Tracer = t }
+
+// RegisterBuiltin makes fn callable by scripts as name,
+// replacing the builtin already named so if any
+func (in *Interpreter) RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	in.ctx.Builtins[name] = &object.Builtin{Fn: fn}
+}
+
+// SetGlobal binds name to val in the root env
+func (in *Interpreter) SetGlobal(name string, val object.Object) {
+	in.env.Set(name, val)
+}
+
+// Global returns the value bound to name in the root env
+func (in *Interpreter) Global(name string) (object.Object, bool) {
+	return in.env.Get(name)
+}
+
+// ParseError holds the errors of a source that failed to parse
+type ParseError struct {
+	Errors []string
+}
+
+func (e *ParseError) Error() string { return strings.Join(e.Errors, "\n") }
+
+// Eval runs source in the root env, returning the value of its last
+// statement. A script failing returns its *object.Error as the error.
+func (in *Interpreter) Eval(source string) (object.Object, error) {
+	return in.EvalContext(context.Background(), source)
+}
+
+// EvalContext is like Eval but stops the script once ctx is done,
+// the error returned then wraps the error of ctx
+func (in *Interpreter) EvalContext(ctx context.Context, source string) (object.Object, error) {
+	in.start(ctx)
+	p := parser.New(lexer.NewFile("<eval>", source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+	evaluator.DefineMacros(program, in.macros)
+	expanded, errObj := evaluator.ExpandMacros(program, in.macros, in.ctx)
+	if errObj != nil {
+		return nil, errObj
+	}
+	return result(evaluator.Eval(expanded, in.env, in.ctx))
+}
+
+// Call calls the function bound to fnName in the root env with args
+func (in *Interpreter) Call(fnName string, args ...object.Object) (object.Object, error) {
+	return in.CallContext(context.Background(), fnName, args...)
+}
+
+// CallContext is like Call but stops the function once ctx is done
+func (in *Interpreter) CallContext(ctx context.Context, fnName string, args ...object.Object) (object.Object, error) {
+	in.start(ctx)
+	fn, ok := in.env.Get(fnName)
+	if !ok {
+		return nil, fmt.Errorf("bariq: no function named %s", fnName)
+	}
+	switch fn.(type) {
+	case *object.Function, *object.Builtin:
+	default:
+		return nil, fmt.Errorf("bariq: %s is not a function: %s", fnName, fn.Type())
+	}
+	return result(evaluator.Apply(fn, args, in.ctx))
+}
+
+// start gives a new run its own budget
+func (in *Interpreter) start(ctx context.Context) {
+	in.ctx.Ctx = ctx
+	in.ctx.Reset()
+}
+
+func result(obj object.Object) (object.Object, error) {
+	if errObj, ok := obj.(*object.Error); ok {
+		return nil, errObj
+	}
+	if obj == nil {
+		return evaluator.NULL, nil
+	}
+	return obj, nil
+}
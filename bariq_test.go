@@ -0,0 +1,185 @@
+package bariq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"bariq/evaluator"
+	"bariq/object"
+	"bariq/sched"
+)
+
+func TestInterpreter(t *testing.T) {
+	in := New()
+	in.RegisterBuiltin("double", func(ctx *object.Context, args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+	in.SetGlobal("base", &object.Integer{Value: 10})
+	if _, err := in.Eval(`let f = fn(x) { double(x) + base };`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res, err := in.Call("f", &object.Integer{Value: 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Inspect() != "18" {
+		t.Errorf("wrong result, want 18, got %s", res.Inspect())
+	}
+	// bindings are kept between calls to Eval
+	res, err = in.Eval(`f(1) + len("ab")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Inspect() != "14" {
+		t.Errorf("wrong result, want 14, got %s", res.Inspect())
+	}
+}
+
+func TestInterpreterIsolation(t *testing.T) {
+	a, b := New(), New()
+	a.RegisterBuiltin("hello", func(ctx *object.Context, args ...object.Object) object.Object {
+		return &object.String{Value: "hi"}
+	})
+	a.SetGlobal("x", &object.Integer{Value: 1})
+	if _, err := a.Eval(`hello() + "!"`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, err := b.Eval(`hello()`)
+	var errObj *object.Error
+	if !errors.As(err, &errObj) || errObj.Message != "ident not found: hello" {
+		t.Errorf("builtin leaked to another interpreter, got %v", err)
+	}
+	if _, ok := b.Global("x"); ok {
+		t.Errorf("global leaked to another interpreter")
+	}
+}
+
+func TestInterpreterTracers(t *testing.T) {
+	var outA, outB bytes.Buffer
+	a, b := New(), New()
+	a.SetTracer(evaluator.NewWriterTracer(&outA, evaluator.TraceEval))
+	b.SetTracer(evaluator.NewWriterTracer(&outB, evaluator.TraceEval))
+	if _, err := a.Eval(`let f = fn(x) { x }; f(1)`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := b.Eval(`let g = fn(x) { x }; g(2)`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := outA.String(); got != "[eval] call f(1) at <eval>:1:23\n[eval] f returned 1\n" {
+		t.Errorf("wrong trace for a, got %q", got)
+	}
+	if got := outB.String(); got != "[eval] call g(2) at <eval>:1:23\n[eval] g returned 2\n" {
+		t.Errorf("wrong trace for b, got %q", got)
+	}
+	a.SetTracer(nil)
+	if _, err := a.Eval(`f(3)`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(outA.String(), "f(3)") {
+		t.Errorf("tracing wasn't disabled, got %q", outA.String())
+	}
+}
+
+func TestInterpreterOutput(t *testing.T) {
+	in := New()
+	var out bytes.Buffer
+	in.Context().Stdout = &out
+	if _, err := in.Eval(`puts("hello")`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "hello\n" {
+		t.Errorf("output not captured, got %q", out.String())
+	}
+}
+
+func TestInterpreterErrors(t *testing.T) {
+	in := New()
+	tests := []struct {
+		run      func() error
+		expected string
+	}{
+		{func() error { _, err := in.Eval(`let = 1`); return err }, "<eval>:1:5: expected the next token to be IDENT, got =\n<eval>:1:5: no prefix function for = found "},
+		{func() error { _, err := in.Eval(`1 + true`); return err }, "ERROR: <eval>:1:3: type mismatch: INTEGER + BOOLEAN"},
+		{func() error { _, err := in.Call("nope"); return err }, "bariq: no function named nope"},
+		{func() error { in.SetGlobal("n", &object.Integer{Value: 1}); _, err := in.Call("n"); return err }, "bariq: n is not a function: INTEGER"},
+	}
+	for _, tt := range tests {
+		err := tt.run()
+		if err == nil {
+			t.Errorf("expected error %q", tt.expected)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error, want %q, got %q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestInterpreterLimits(t *testing.T) {
+	tests := []struct {
+		limits object.Limits
+		input  string
+		err    error
+	}{
+		{object.Limits{MaxSteps: 100}, `let f = fn(n) { f(n + 1) }; f(0)`, object.ErrStepLimit},
+		{object.Limits{MaxDepth: 50}, `let f = fn(n) { f(n + 1) }; f(0)`, object.ErrDepthLimit},
+		// the default depth stops runaway recursions before the go stack does
+		{object.Limits{}, `let f = fn(n) { f(n + 1) }; f(0)`, object.ErrDepthLimit},
+		// awaiting a queued task runs it on the awaiting goroutine
+		{object.Limits{MaxDepth: 50}, `let f = async fn(n) { await(f(n + 1)) }; await(f(0))`, object.ErrDepthLimit},
+		{object.Limits{}, `let f = async fn(n) { await(f(n + 1)) }; await(f(0))`, object.ErrDepthLimit},
+		{object.Limits{MaxAlloc: 1000}, `let f = fn(a) { f(push(a, "xxxxxxxx")) }; f([])`, object.ErrAllocLimit},
+		{object.Limits{MaxAlloc: 1000}, `chan(1000)`, object.ErrAllocLimit},
+	}
+	for _, tt := range tests {
+		in := New()
+		in.SetLimits(tt.limits)
+		_, err := in.Eval(tt.input)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: want %v, got %v", tt.input, tt.err, err)
+		}
+	}
+
+	// the budget is reset by each call
+	in := New()
+	in.SetLimits(object.Limits{MaxSteps: 50})
+	for i := 0; i < 3; i++ {
+		if _, err := in.Eval(`let x = 1 + 2 + 3;`); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
+
+func TestInterpreterDeadline(t *testing.T) {
+	in := New()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := in.EvalContext(ctx, `sleep(1000000)`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want a deadline error, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("sleep wasn't interrupted")
+	}
+
+}
+
+func TestAsyncPanic(t *testing.T) {
+	in := New()
+	in.RegisterBuiltin("boom", func(ctx *object.Context, args ...object.Object) object.Object {
+		panic("boom")
+	})
+	_, err := in.Eval(`let f = async fn() { boom() }; await(f())`)
+	var panicErr *sched.PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Fatalf("want the panic of the task, got %v", err)
+	}
+	if err.Error() != "ERROR: <eval>:1:32: async task panicked: boom" {
+		t.Errorf("wrong error, got %q", err.Error())
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"bariq/compiler"
+	"bariq/evaluator"
+	"bariq/lexer"
+	"bariq/object"
+	"bariq/parser"
+	"bariq/repl"
+	"bariq/sched"
+	"bariq/typecheck"
+	"bariq/vm"
+)
+
+var pl = fmt.Println
+
+const usage = `usage: bariq <command> [arguments]
+
+commands:
+  run <file.bq>    run a script file, "-" or no file reads stdin
+  eval -e <src>    evaluate the given source and print its result
+                   both accept -typecheck to check types before evaluating
+                   and -backend=vm to run on the bytecode vm
+                   -trace=eval,gen,async prints evaluator events to stderr
+                   or to the file given by -trace-file
+                   -max-steps, -max-depth, -max-alloc and -timeout
+                   limit what the program can use
+                   -concurrency=n runs up to n async calls at once
+  repl             start an interactive session (default)
+
+a script path can also be given directly: bariq file.bq
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		startRepl()
+		return
+	}
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "run":
+		os.Exit(runCmd(args))
+	case "eval":
+		os.Exit(evalCmd(args))
+	case "repl":
+		startRepl()
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		// allows `#!/usr/bin/env bariq` shebang lines
+		if strings.HasPrefix(cmd, "-") {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		os.Exit(runCmd(os.Args[1:]))
+	}
+}
+
+func startRepl() {
+	user, err := user.Current()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Hello %s, Starting now...\n", user.Username)
+	repl.Start(os.Stdin, os.Stdout)
+}
+
+// runOptions are the flags shared by run and eval
+type runOptions struct {
+	printResult bool
+	typecheck   bool
+	// eval or vm
+	backend string
+	// comma separated trace kinds, written to traceFile or stderr
+	trace     string
+	traceFile string
+	limits    object.Limits
+	timeout   time.Duration
+	workers   int
+}
+
+func (o *runOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.typecheck, "typecheck", false, "check types before evaluating")
+	fs.StringVar(&o.backend, "backend", "eval", "backend running the program: eval or vm")
+	fs.StringVar(&o.trace, "trace", "", "trace evaluator events: eval, gen, async")
+	fs.StringVar(&o.traceFile, "trace-file", "", "write traces to this file instead of stderr")
+	fs.Int64Var(&o.limits.MaxSteps, "max-steps", 0, "stop after evaluating this many nodes, 0 for no limit")
+	fs.IntVar(&o.limits.MaxDepth, "max-depth", object.DefaultMaxDepth, "maximum number of nested calls")
+	fs.Int64Var(&o.limits.MaxAlloc, "max-alloc", 0, "stop after allocating about this many bytes, 0 for no limit")
+	fs.DurationVar(&o.timeout, "timeout", 0, "stop the program after this long, ex: 2s, 0 for no limit")
+	fs.IntVar(&o.workers, "concurrency", sched.DefaultWorkers, "number of async calls running at once")
+}
+
+// setupTracer returns the tracer asked by the options, nil if
+// none is, the returned func closes its trace file if any
+func (o *runOptions) setupTracer(errOut io.Writer) (object.Tracer, func(), error) {
+	if o.trace == "" {
+		return nil, func() {}, nil
+	}
+	kinds, err := evaluator.ParseTraceKinds(o.trace)
+	if err != nil {
+		return nil, nil, err
+	}
+	w, closeFile := errOut, func() error { return nil }
+	if o.traceFile != "" {
+		f, err := os.Create(o.traceFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closeFile = f, f.Close
+	}
+	return evaluator.NewWriterTracer(w, kinds...), func() { closeFile() }, nil
+}
+
+func runCmd(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var opts runOptions
+	opts.register(fs)
+	fs.Parse(args)
+	name := "-"
+	if fs.NArg() > 0 {
+		name = fs.Arg(0)
+	}
+	var (
+		src []byte
+		err error
+	)
+	if name == "-" {
+		name = "<stdin>"
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bariq: %s\n", err)
+		return 1
+	}
+	return runSource(name, string(src), os.Stdout, os.Stderr, opts)
+}
+
+func evalCmd(args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	src := fs.String("e", "", "source to evaluate")
+	opts := runOptions{printResult: true}
+	opts.register(fs)
+	fs.Parse(args)
+	if *src == "" {
+		fmt.Fprintln(os.Stderr, "bariq eval: missing -e <src>")
+		return 2
+	}
+	return runSource("<eval>", *src, os.Stdout, os.Stderr, opts)
+}
+
+// runSource parses and evaluates a whole program, reporting parse errors
+// with their position in name, the exit code is non zero when the
+// program fails to parse, type check or evaluates to an error.
+func runSource(name, src string, out, errOut io.Writer, opts runOptions) int {
+	tracer, closeTrace, err := opts.setupTracer(errOut)
+	if err != nil {
+		fmt.Fprintf(errOut, "bariq: %s\n", err)
+		return 2
+	}
+	defer closeTrace()
+	l := lexer.NewFile(name, stripShebang(src))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			// messages carry the file name in their position
+			fmt.Fprintln(errOut, msg)
+		}
+		return 1
+	}
+	macroEnv := object.NewEnv()
+	evaluator.DefineMacros(program, macroEnv)
+	ctx := &object.Context{
+		Stdout: out,
+		Stderr: errOut,
+		Stdin:  os.Stdin,
+		Clock:  object.SystemClock,
+		Ctx:    context.Background(),
+		Limits: opts.limits,
+		Sched:  sched.New(opts.workers),
+		Tracer: tracer,
+	}
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx.Ctx, cancel = context.WithTimeout(ctx.Ctx, opts.timeout)
+		defer cancel()
+	}
+	expanded, errObj := evaluator.ExpandMacros(program, macroEnv, ctx)
+	if errObj != nil {
+		fmt.Fprintln(errOut, errObj.Traceback())
+		return 1
+	}
+	if opts.typecheck {
+		if errs := typecheck.Check(expanded); len(errs) != 0 {
+			for _, msg := range errs {
+				fmt.Fprintln(errOut, msg)
+			}
+			return 1
+		}
+	}
+	var evaluated object.Object
+	switch opts.backend {
+	case "eval", "":
+		evaluated = evaluator.Eval(expanded, object.NewEnv(), ctx)
+	case "vm":
+		c := compiler.NewWithBuiltins(ctx.Builtins)
+		if err := c.Compile(expanded); err != nil {
+			fmt.Fprintln(errOut, err)
+			return 1
+		}
+		evaluated = vm.New(c.Bytecode(), ctx).Run()
+	default:
+		fmt.Fprintf(errOut, "bariq: unknown backend %q\n", opts.backend)
+		return 2
+	}
+	if errObj, ok := evaluated.(*object.Error); ok {
+		fmt.Fprintln(errOut, errObj.Traceback())
+		return 1
+	}
+	if opts.printResult && evaluated != nil && evaluated != evaluator.NULL {
+		fmt.Fprintln(out, evaluated.Inspect())
+	}
+	return 0
+}
+
+// stripShebang blanks a leading `#!` line, keeping the newline so
+// line numbers stay the same
+func stripShebang(src string) string {
+	if !strings.HasPrefix(src, "#!") {
+		return src
+	}
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		return src[i:]
+	}
+	return ""
+}
@@ -0,0 +1,697 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"bariq/ast"
+	"bariq/code"
+	"bariq/evaluator"
+	"bariq/object"
+	"bariq/token"
+)
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function being compiled
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	positions           map[int]token.Position
+	callNames           map[int]string
+	// loops around the instructions being compiled, innermost last
+	loops []*loop
+	// values left on the stack by the expression being compiled,
+	// ex: the right operand while the left one is compiled
+	temps int
+}
+
+// loop is a loop being compiled, its breaks are patched
+// to jump to its end once it is known
+type loop struct {
+	// where a continue jumps to
+	start  int
+	breaks []int
+	// the temps of the body, a break or a continue
+	// pops those of the expressions around it
+	temps int
+}
+
+func newCompilationScope() CompilationScope {
+	return CompilationScope{
+		instructions: code.Instructions{},
+		positions:    make(map[int]token.Position),
+		callNames:    make(map[int]string),
+	}
+}
+
+type Compiler struct {
+	// names of the builtin slots, by index
+	builtins    []string
+	constants   []object.Object
+	symbolTable *SymbolTable
+	scopes      []CompilationScope
+	scopeIndex  int
+}
+
+// New returns a compiler for programs using the default builtins
+func New() *Compiler { return NewWithBuiltins(nil) }
+
+// NewWithBuiltins returns a compiler for programs using the builtins
+// of table, ex: the object.Context.Builtins they run with, the
+// default builtins when it is nil
+func NewWithBuiltins(table map[string]*object.Builtin) *Compiler {
+	names := evaluator.BuiltinNames()
+	if table != nil {
+		names = make([]string, 0, len(table))
+		for name := range table {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	symbolTable := NewSymbolTable()
+	for i, name := range names {
+		symbolTable.DefineBuiltin(i, name)
+	}
+	return &Compiler{
+		builtins:    names,
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{newCompilationScope()},
+	}
+}
+
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	// names of the global slots, by index
+	Globals []string
+	// names of the builtin slots, by index
+	Builtins  []string
+	Positions map[int]token.Position
+	CallNames map[int]string
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	names := c.symbolTable.globals().localNames()
+	scope := c.scopes[c.scopeIndex]
+	return &Bytecode{
+		Instructions: scope.instructions,
+		Constants:    c.constants,
+		Globals:      names,
+		Builtins:     c.builtins,
+		Positions:    scope.positions,
+		CallNames:    scope.callNames,
+	}
+}
+
+// Assignment is the constant of an OpAssign, the value and the
+// indexes it assigns at are on the stack, the outermost index first
+type Assignment struct {
+	Symbol Symbol
+	// operator of a compound assignment, ex: + for +=
+	Operator string
+	Indexes  int
+	// where the errors of the name, of the container at each
+	// index and of the assignment itself are reported
+	Positions []token.Position
+}
+
+func (a *Assignment) Type() object.ObjectType { return "ASSIGNMENT" }
+func (a *Assignment) Inspect() string         { return "<assignment to " + a.Symbol.Name + ">" }
+
+// unsupported reports a node only the evaluator can run
+func unsupported(node ast.Node, what string) error {
+	return fmt.Errorf("%s: %s is not supported by the vm backend", node.Pos(), what)
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Stmts {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+	case *ast.ExprStmt:
+		if err := c.Compile(node.Expr); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	case *ast.BlockStmt:
+		for _, s := range node.Stmts {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+	case *ast.LetStmt:
+		// the value is compiled first so it sees
+		// the previous binding of the name, if any
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+			if err := c.compileFunction(fn, node.Name.Value); err != nil {
+				return err
+			}
+		} else if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		sym, ok := c.symbolTable.Declare(node.Name.Value, node.Const)
+		if !ok {
+			return fmt.Errorf("%s: cannot redeclare const %s", node.Pos(), node.Name.Value)
+		}
+		c.storeSymbol(sym)
+	case *ast.ReturnStmt:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.IntLiteral:
+		if node.Big != nil {
+			c.emit(code.OpConstant, c.addConstant(&object.BigInt{Value: node.Big}))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+		}
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.PrefixExpr:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emitAt(node, code.OpBang)
+		case "-":
+			c.emitAt(node, code.OpMinus)
+		default:
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+	case *ast.InfixExpr:
+		return c.compileInfixExpr(node)
+	case *ast.IfExpr:
+		return c.compileIfExpr(node)
+	case *ast.Ident:
+		c.loadSymbol(node, c.resolve(node.Value))
+	case *ast.ArrayLiteral:
+		if err := c.compileOperands(node.Elmnts...); err != nil {
+			return err
+		}
+		c.emit(code.OpArray, len(node.Elmnts))
+	case *ast.HashLiteral:
+		// sorted so the output doesn't depend on map order
+		keys := []ast.Expr{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		operands := []ast.Expr{}
+		for _, k := range keys {
+			operands = append(operands, k, node.Pairs[k])
+		}
+		if err := c.compileOperands(operands...); err != nil {
+			return err
+		}
+		c.emitAt(node, code.OpHash, len(node.Pairs)*2)
+	case *ast.IndexExpr:
+		if err := c.compileOperands(node.Left, node.Index); err != nil {
+			return err
+		}
+		c.emitAt(node, code.OpIndex)
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, "")
+	case *ast.CallExpr:
+		return c.compileCallExpr(node)
+
+	case *ast.AwaitExpr:
+		return unsupported(node, "await")
+	case *ast.YieldExpr:
+		return unsupported(node, "yield")
+	case *ast.ImportExpr:
+		return unsupported(node, "import")
+	case *ast.MacroLiteral:
+		return unsupported(node, "macro")
+	case *ast.SelectExpr:
+		return unsupported(node, "select")
+	case *ast.AssignExpr:
+		return c.compileAssignExpr(node)
+	case *ast.ForInExpr:
+		return c.compileForInExpr(node)
+	case *ast.WhileExpr:
+		return c.compileWhileExpr(node)
+	case *ast.BreakStmt:
+		return c.compileLoopJump(node, true)
+	case *ast.ContinueStmt:
+		return c.compileLoopJump(node, false)
+	default:
+		return fmt.Errorf("%s: can't compile %T", node.Pos(), node)
+	}
+	return nil
+}
+
+var infixOps = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"<=": code.OpLessEqual,
+	">=": code.OpGreaterEqual,
+}
+
+func (c *Compiler) compileInfixExpr(node *ast.InfixExpr) error {
+	if node.Operator == "&&" || node.Operator == "||" {
+		return c.compileLogicalExpr(node)
+	}
+	op, ok := infixOps[node.Operator]
+	if !ok {
+		return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+	}
+	// the evaluator evaluates the right operand first
+	if err := c.compileOperands(node.Right, node.Left); err != nil {
+		return err
+	}
+	c.emitAt(node, op)
+	return nil
+}
+
+// compileOperands compiles nodes leaving their values on the stack
+func (c *Compiler) compileOperands(nodes ...ast.Expr) error {
+	for _, n := range nodes {
+		if err := c.Compile(n); err != nil {
+			return err
+		}
+		c.scopes[c.scopeIndex].temps++
+	}
+	c.scopes[c.scopeIndex].temps -= len(nodes)
+	return nil
+}
+
+// compileLogicalExpr skips the right operand of && and || when
+// the left one decides the result, the left one is kept as the result
+func (c *Compiler) compileLogicalExpr(node *ast.InfixExpr) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	jump := code.OpJumpNotTruthyOrPop
+	if node.Operator == "||" {
+		jump = code.OpJumpTruthyOrPop
+	}
+	jumpPos := c.emit(jump, 9999)
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+func (c *Compiler) compileIfExpr(node *ast.IfExpr) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	// bogus offsets, patched once the blocks are compiled
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileAssignExpr compiles an assignment to a name or to an
+// element of it, the indexes are evaluated after the value
+func (c *Compiler) compileAssignExpr(node *ast.AssignExpr) error {
+	operands := []ast.Expr{node.Value}
+	positions := []token.Position{node.Pos()}
+	target := node.Target
+	for {
+		ie, ok := target.(*ast.IndexExpr)
+		if !ok {
+			break
+		}
+		operands = append(operands, ie.Index)
+		positions = append(positions, ie.Pos())
+		target = ie.Left
+	}
+	name, ok := target.(*ast.Ident)
+	if !ok {
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+	if err := c.compileOperands(operands...); err != nil {
+		return err
+	}
+	positions = append(positions, name.Pos())
+	// from the name to the assignment
+	for i, j := 0, len(positions)-1; i < j; i, j = i+1, j-1 {
+		positions[i], positions[j] = positions[j], positions[i]
+	}
+	sym := c.resolve(name.Value)
+	if sym.Scope == FunctionScope {
+		// the name a function refers to itself by
+		// is bound by the scope enclosing it
+		sym = c.symbolTable.rebindFunctionName(name.Value)
+	}
+	c.emit(code.OpAssign, c.addConstant(&Assignment{
+		Symbol:    sym,
+		Operator:  strings.TrimSuffix(node.Operator, "="),
+		Indexes:   len(operands) - 1,
+		Positions: positions,
+	}))
+	return nil
+}
+
+// compileWhileExpr compiles a while loop, which evaluates to null
+func (c *Compiler) compileWhileExpr(node *ast.WhileExpr) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+	if err := c.compileLoopBody(node.Body, start); err != nil {
+		return err
+	}
+	c.changeOperand(exitPos, len(c.currentInstructions()))
+	c.emit(code.OpNull)
+	return nil
+}
+
+// compileForInExpr compiles a for loop, the iterator stays on the
+// stack below the values of the body until the loop ends
+func (c *Compiler) compileForInExpr(node *ast.ForInExpr) error {
+	if node.Await {
+		return unsupported(node, "for await")
+	}
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	names := 1
+	if node.Key != nil {
+		names = 2
+	}
+	c.emitAt(node.Iterable, code.OpIter, names)
+	c.scopes[c.scopeIndex].temps++
+	start := c.emit(code.OpNext, 9999)
+	// the value is above the key
+	for _, name := range []*ast.Ident{node.Name, node.Key}[:names] {
+		sym, ok := c.symbolTable.Declare(name.Value, false)
+		if !ok {
+			return fmt.Errorf("%s: cannot assign to const %s", name.Pos(), name.Value)
+		}
+		c.storeSymbol(sym)
+	}
+	if err := c.compileLoopBody(node.Body, start); err != nil {
+		return err
+	}
+	c.changeOperand(start, len(c.currentInstructions()))
+	c.scopes[c.scopeIndex].temps--
+	c.emit(code.OpPop)
+	c.emit(code.OpNull)
+	return nil
+}
+
+// compileLoopBody compiles the body of a loop starting at start,
+// followed by the jump back to it, the breaks of the body jump
+// to the instruction following it
+func (c *Compiler) compileLoopBody(body *ast.BlockStmt, start int) error {
+	l := &loop{start: start, temps: c.scopes[c.scopeIndex].temps}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, l)
+	if err := c.Compile(body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileLoopJump compiles a break, or a continue, popping the
+// values of the expressions it leaves
+func (c *Compiler) compileLoopJump(node ast.Node, isBreak bool) error {
+	scope := c.scopes[c.scopeIndex]
+	if len(scope.loops) == 0 {
+		return fmt.Errorf("%s: %s outside of a loop", node.Pos(), node.TokenLiteral())
+	}
+	l := scope.loops[len(scope.loops)-1]
+	for i := l.temps; i < scope.temps; i++ {
+		c.emit(code.OpPop)
+	}
+	if !isBreak {
+		c.emit(code.OpJump, l.start)
+		return nil
+	}
+	l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+	return nil
+}
+
+// compileBlockValue compiles a block leaving the value
+// of its last statement on the stack
+func (c *Compiler) compileBlockValue(block *ast.BlockStmt) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	switch {
+	case c.lastInstructionIs(code.OpPop):
+		c.removeLastPop()
+	case !c.lastInstructionIs(code.OpReturnValue):
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// compileFunction compiles a function literal,
+// name is the name it is bound to by let, if any
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral, name string) error {
+	if node.Async {
+		return unsupported(node, "async function")
+	}
+	if node.Gen {
+		return unsupported(node, "generator function")
+	}
+	c.enterScope()
+	c.symbolTable.declares = declaredNames(node.Body)
+	if name != "" {
+		c.symbolTable.DefineFunctionName(name)
+	}
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	switch {
+	case c.lastInstructionIs(code.OpPop):
+		c.replaceLastPopWithReturn()
+	case !c.lastInstructionIs(code.OpReturnValue):
+		c.emit(code.OpReturn)
+	}
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.localNames()
+	scope := c.leaveScope()
+	for _, s := range freeSymbols {
+		c.loadCell(node, s)
+	}
+	fn := &object.CompiledFunction{
+		Instructions:  scope.instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Name:          name,
+		Positions:     scope.positions,
+		CallNames:     scope.callNames,
+		LocalNames:    localNames,
+	}
+	for _, s := range freeSymbols {
+		fn.FreeNames = append(fn.FreeNames, s.Name)
+	}
+	if numLocals > 255 {
+		return fmt.Errorf("%s: too many local bindings in function", node.Pos())
+	}
+	c.emit(code.OpClosure, c.addConstant(fn), len(freeSymbols))
+	return nil
+}
+
+func (c *Compiler) compileCallExpr(node *ast.CallExpr) error {
+	if ident, ok := node.Function.(*ast.Ident); ok && ident.Value == "quote" {
+		return unsupported(node, "quote")
+	}
+	if err := c.compileOperands(append([]ast.Expr{node.Function}, node.Args...)...); err != nil {
+		return err
+	}
+	pos := c.emitAt(node, code.OpCall, len(node.Args))
+	if ident, ok := node.Function.(*ast.Ident); ok {
+		c.scopes[c.scopeIndex].callNames[pos] = ident.Value
+	}
+	return nil
+}
+
+// resolve finds the symbol of name, names not bound yet are given
+// a global slot, it is an error to read it before it is set
+func (c *Compiler) resolve(name string) Symbol {
+	sym, ok := c.symbolTable.Resolve(name)
+	if !ok || sym.Scope == GlobalScope || sym.Scope == BuiltinScope {
+		// the closures see the bindings of the functions
+		// enclosing them when they are called
+		if sym, ok := c.symbolTable.resolveLater(name); ok {
+			return sym
+		}
+	}
+	if ok {
+		return sym
+	}
+	c.symbolTable.globals().Define(name)
+	sym, _ = c.symbolTable.Resolve(name)
+	return sym
+}
+
+func (c *Compiler) loadSymbol(node ast.Node, s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emitAt(node, code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emitAt(node, code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emitAt(node, code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emitAt(node, code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// storeSymbol pops the value on the stack into the slot of s
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// loadCell pushes what a closure captures of s, the variables of
+// the enclosing function are shared through cells so assignments
+// to them are seen by both
+func (c *Compiler) loadCell(node ast.Node, s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpGetLocalCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		c.loadSymbol(node, s)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// emit appends an instruction, returning its offset
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	c.setLastInstruction(op, pos)
+	return pos
+}
+
+// emitAt is like emit for instructions that can fail,
+// their errors are reported at the position of node
+func (c *Compiler) emitAt(node ast.Node, op code.Opcode, operands ...int) int {
+	pos := c.emit(op, operands...)
+	c.scopes[c.scopeIndex].positions[pos] = node.Pos()
+	return pos
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	copy(ins[pos:], newInstruction)
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.replaceInstruction(opPos, code.Make(op, operand))
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, newCompilationScope())
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() CompilationScope {
+	scope := c.scopes[c.scopeIndex]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return scope
+}
+
+// declaredNames returns the names body binds with let, for or
+// select, those bound by the functions it defines are theirs
+func declaredNames(body *ast.BlockStmt) map[string]bool {
+	names := map[string]bool{}
+	for _, name := range ast.Binders(body) {
+		names[name.Value] = true
+	}
+	return names
+}
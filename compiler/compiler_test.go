@@ -0,0 +1,231 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"bariq/ast"
+	"bariq/code"
+	"bariq/evaluator"
+	"bariq/lexer"
+	"bariq/object"
+	"bariq/parser"
+)
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input        string
+		instructions []code.Instructions
+	}{
+		{
+			// the right operand is compiled first
+			"1 - 2",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSub),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"if (true) { 10 }; 3333;",
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// the right operand is skipped when the left one decides
+			"true && 1; 2",
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthyOrPop, 7),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"let one = 1; let one = 2; one",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// g gets a slot when f refers to it
+			"let f = fn() { g() }; let g = fn() { 1 };",
+			[]code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			`first([1, 2])`,
+			[]code.Instructions{
+				code.Make(code.OpGetBuiltin, builtinIndex("first")),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// the break pops the left operand it leaves
+			"while (true) { [1, if (true) { break }] }",
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 27),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 19),
+				code.Make(code.OpPop),
+				code.Make(code.OpJump, 27),
+				code.Make(code.OpNull),
+				code.Make(code.OpJump, 20),
+				code.Make(code.OpNull),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpPop),
+				code.Make(code.OpJump, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// the value is below the indexes, the outermost first
+			"let a = [[1]]; a[0][1] += 2",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpAssign, 4),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"for (k, v in [1]) { continue }",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpIter, 2),
+				code.Make(code.OpNext, 23),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpJump, 8),
+				code.Make(code.OpJump, 8),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	for _, tt := range tests {
+		c := New()
+		if err := c.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		want := concatInstructions(tt.instructions)
+		got := c.Bytecode().Instructions
+		if got.String() != want.String() {
+			t.Errorf("wrong instructions for %q.\nwant\n%s\ngot\n%s", tt.input, want, got)
+		}
+	}
+}
+
+func TestCompileClosures(t *testing.T) {
+	input := `fn(a) { fn(b) { a + b } }`
+	c := New()
+	if err := c.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	constants := c.Bytecode().Constants
+	inner, ok := constants[0].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant is not a CompiledFunction, got %T", constants[0])
+	}
+	want := concatInstructions([]code.Instructions{
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturnValue),
+	})
+	if inner.Instructions.String() != want.String() {
+		t.Errorf("wrong inner instructions.\nwant\n%s\ngot\n%s", want, inner.Instructions)
+	}
+	outer := constants[1].(*object.CompiledFunction)
+	// a is captured through a cell
+	want = concatInstructions([]code.Instructions{
+		code.Make(code.OpGetLocalCell, 0),
+		code.Make(code.OpClosure, 0, 1),
+		code.Make(code.OpReturnValue),
+	})
+	if outer.Instructions.String() != want.String() {
+		t.Errorf("wrong outer instructions.\nwant\n%s\ngot\n%s", want, outer.Instructions)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let f = async fn() { 1 };`, "1:15: async function is not supported by the vm backend"},
+		{`let g = fn gen () { yield 1 };`, "1:9: generator function is not supported by the vm backend"},
+		{`import "x.bq"`, "1:1: import is not supported by the vm backend"},
+		{`quote(1)`, "1:6: quote is not supported by the vm backend"},
+		{`for await (x in [1]) { x }`, "1:1: for await is not supported by the vm backend"},
+		{`const x = 1; let f = fn() { const x = 2; x }; let x = 3`, "1:47: cannot redeclare const x"},
+	}
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("expected an error for %q", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error, want %q, got %q", tt.expected, err)
+		}
+	}
+}
+
+// builtinIndex is the index the compiler gives to a builtin
+func builtinIndex(name string) int {
+	for i, n := range evaluator.BuiltinNames() {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,168 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+	// declared with const
+	Const bool
+	// given a slot by a closure referring to it before the let
+	// binding it, the function itself sees it once declared
+	pending bool
+}
+
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+	// symbols of enclosing functions used by this one
+	FreeSymbols []Symbol
+	// names the function binds with let, for or select, the
+	// closures it defines can refer to them before the binding
+	declares map[string]bool
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol)}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define binds name in this table, defining a name twice in
+// the same table reuses its slot like `let` does with an Env
+func (s *SymbolTable) Define(name string) Symbol {
+	if sym, ok := s.store[name]; ok && (sym.Scope == GlobalScope || sym.Scope == LocalScope) {
+		return sym
+	}
+	sym := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		sym.Scope = GlobalScope
+	} else {
+		sym.Scope = LocalScope
+	}
+	s.store[name] = sym
+	s.numDefinitions++
+	return sym
+}
+
+// Declare is Define for a let or a const binding,
+// it fails when name is already a const of this table
+func (s *SymbolTable) Declare(name string, constant bool) (Symbol, bool) {
+	if sym, ok := s.store[name]; ok && sym.Const {
+		return sym, false
+	}
+	sym := s.Define(name)
+	sym.Const = constant
+	sym.pending = false
+	s.store[name] = sym
+	return sym, true
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	sym := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = sym
+	return sym
+}
+
+// DefineFunctionName lets a function refer to itself
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	sym := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = sym
+	return sym
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	sym := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope, Const: original.Const}
+	s.store[original.Name] = sym
+	return sym
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+// resolve is Resolve, inner is set for the names used by a function
+// nested in the one of s, which can be bound after they are used
+func (s *SymbolTable) resolve(name string, inner bool) (Symbol, bool) {
+	sym, ok := s.store[name]
+	if ok && (inner || !sym.pending) || s.Outer == nil {
+		return sym, ok
+	}
+	sym, ok = s.Outer.resolve(name, true)
+	if !ok {
+		return sym, ok
+	}
+	if sym.Scope == GlobalScope || sym.Scope == BuiltinScope {
+		return sym, ok
+	}
+	return s.defineFree(sym), true
+}
+
+// resolveLater gives a slot to name in the innermost function
+// enclosing the one of s that binds it later, if any, returning
+// the symbol of name in s
+func (s *SymbolTable) resolveLater(name string) (Symbol, bool) {
+	for t := s.Outer; t != nil && t.Outer != nil; t = t.Outer {
+		if _, ok := t.store[name]; ok {
+			break
+		}
+		if t.declares[name] {
+			sym := t.Define(name)
+			sym.pending = true
+			t.store[name] = sym
+			return s.Resolve(name)
+		}
+	}
+	return Symbol{}, false
+}
+
+// rebindFunctionName makes name, the name of the function of s,
+// refer to the binding of the scope enclosing the function
+func (s *SymbolTable) rebindFunctionName(name string) Symbol {
+	sym, ok := s.Outer.Resolve(name)
+	if !ok {
+		s.globals().Define(name)
+		sym, _ = s.Outer.Resolve(name)
+	}
+	if sym.Scope == GlobalScope || sym.Scope == BuiltinScope {
+		delete(s.store, name)
+		return sym
+	}
+	return s.defineFree(sym)
+}
+
+// localNames are the names of the slots of the table, by index,
+// ex: the names of the globals for the outermost one
+func (s *SymbolTable) localNames() []string {
+	names := make([]string, s.numDefinitions)
+	for name, sym := range s.store {
+		if sym.Scope == LocalScope || sym.Scope == GlobalScope {
+			names[sym.Index] = name
+		}
+	}
+	return names
+}
+
+// globals is the outermost table
+func (s *SymbolTable) globals() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
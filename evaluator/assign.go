@@ -0,0 +1,127 @@
+package evaluator
+
+import (
+	"strings"
+
+	"bariq/ast"
+	"bariq/object"
+	"bariq/token"
+)
+
+// evalAssignExpr evaluates the value then stores it in the target,
+// a compound operator like += applies its infix operator to the
+// current value first. The value assigned is the result.
+func evalAssignExpr(node *ast.AssignExpr, env *object.Env, ctx *object.Context) object.Object {
+	val := Eval(node.Value, env, ctx)
+	if interrupts(val) {
+		return val
+	}
+	op := strings.TrimSuffix(node.Operator, "=")
+	update := func(old object.Object) object.Object {
+		if op == "" {
+			return val
+		}
+		return alloc(ctx, evalInfixExpr(op, old, val))
+	}
+	return at(node, assign(node.Target, update, env, ctx))
+}
+
+// assign replaces the value of target with update(old value).
+// arrays and hashes are never mutated, a[i] = v rebinds a to a
+// copy of the array with v at i, so other references to the
+// array keep seeing its old elements.
+func assign(
+	target ast.Expr,
+	update func(object.Object) object.Object,
+	env *object.Env,
+	ctx *object.Context,
+) object.Object {
+	switch target := target.(type) {
+	case *ast.Ident:
+		// the update is done with the binding locked, so tasks
+		// running x += 1 at once don't lose increments
+		val, ok := env.Update(target.Value, update)
+		if !ok {
+			return at(target, newError("cannot assign to undeclared %s", target.Value))
+		}
+		return val
+	case *ast.IndexExpr:
+		// evaluated before the binding is locked
+		index := Eval(target.Index, env, ctx)
+		if interrupts(index) {
+			return index
+		}
+		var val object.Object
+		err := assign(target.Left, func(container object.Object) object.Object {
+			return setElement(ctx, container, index, target.Pos(), func(old object.Object) object.Object {
+				val = update(old)
+				return val
+			})
+		}, env, ctx)
+		if isError(err) {
+			return err
+		}
+		return val
+	default:
+		return newError("cannot assign to %s", target.String())
+	}
+}
+
+// setIndex is a copy of container with val at index
+// setElement returns a copy of container with its element at index
+// replaced by update(old element), the errors of container itself
+// are reported at pos
+func setElement(
+	ctx *object.Context,
+	container, index object.Object,
+	pos token.Position,
+	update func(object.Object) object.Object,
+) object.Object {
+	switch container.(type) {
+	case *object.Array, *object.Hash:
+	default:
+		return atPos(pos, newError("index assignment not supported: %s", container.Type()))
+	}
+	if isFrozen(container) {
+		return atPos(pos, newError("cannot assign to an element of a frozen %s", container.Type()))
+	}
+	old := evalIndexExpr(container, index)
+	if isError(old) {
+		return atPos(pos, old)
+	}
+	val := update(old)
+	if isError(val) {
+		return val
+	}
+	return atPos(pos, alloc(ctx, setIndex(container, index, val)))
+}
+
+func setIndex(container, index, val object.Object) object.Object {
+	switch container := container.(type) {
+	case *object.Array:
+		if index.Type() != object.INT_OBJ {
+			return newError("index operator not supported: %s[%s]", container.Type(), index.Type())
+		}
+		// a BigInt is out of range
+		i, ok := index.(*object.Integer)
+		if !ok || i.Value < 0 || i.Value >= int64(len(container.Elements)) {
+			return newError("index out of range: %s, len %d", index.Inspect(), len(container.Elements))
+		}
+		elements := make([]object.Object, len(container.Elements))
+		copy(elements, container.Elements)
+		elements[i.Value] = val
+		return &object.Array{Elements: elements}
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		pairs := make(map[object.HashKey]object.HashPair, len(container.Pairs)+1)
+		for k, v := range container.Pairs {
+			pairs[k] = v
+		}
+		pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+		return &object.Hash{Pairs: pairs}
+	}
+	return newError("index assignment not supported: %s", container.Type())
+}
@@ -0,0 +1,98 @@
+package evaluator
+
+import (
+	"sort"
+
+	"bariq/object"
+	"bariq/token"
+)
+
+// the functions below expose the semantics of the evaluator to
+// other backends (ex: the vm), so both give the same results
+
+func Infix(op string, left, right object.Object) object.Object {
+	return evalInfixExpr(op, left, right)
+}
+
+func Prefix(op string, right object.Object) object.Object {
+	return evalPrefixExpr(op, right)
+}
+
+func Index(left, index object.Object) object.Object {
+	return evalIndexExpr(left, index)
+}
+
+// Alloc counts the memory used by obj against the limits
+// of ctx, it is obj or the error of an exceeded limit
+func Alloc(ctx *object.Context, obj object.Object) object.Object {
+	return alloc(ctx, obj)
+}
+
+// Assign returns old with its element at indexes[0], or nested at
+// indexes[1:], replaced by update of it, update(old) without indexes.
+// the errors of each nested container are reported at pos[i], ex:
+// for a[i][j] = v, indexes are [i, j] and old is a
+func Assign(
+	ctx *object.Context,
+	old object.Object,
+	indexes []object.Object,
+	pos []token.Position,
+	update func(object.Object) object.Object,
+) object.Object {
+	if len(indexes) == 0 {
+		return update(old)
+	}
+	return setElement(ctx, old, indexes[0], pos[0], func(el object.Object) object.Object {
+		return Assign(ctx, el, indexes[1:], pos[1:], update)
+	})
+}
+
+// SortedPairs returns the pairs of h in the order for loops use
+func SortedPairs(h *object.Hash) []object.HashPair {
+	return sortedPairs(h)
+}
+
+// EnvSize is the memory counted for a call with params parameters
+func EnvSize(params int) int64 {
+	return envSize(params)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+func NewError(format string, a ...any) *object.Error {
+	return newError(format, a...)
+}
+
+// BuiltinNames lists the builtins sorted by name,
+// the order can be used to refer to them by index
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupBuiltin finds a builtin in the table of ctx if it has
+// one, in the default builtins otherwise
+func LookupBuiltin(name string, ctx *object.Context) (*object.Builtin, bool) {
+	if ctx != nil && ctx.Builtins != nil {
+		b, ok := ctx.Builtins[name]
+		return b, ok
+	}
+	b, ok := builtins[name]
+	return b, ok
+}
+
+// Builtins returns a copy of the default builtins,
+// to be extended and set as object.Context.Builtins
+func Builtins() map[string]*object.Builtin {
+	table := make(map[string]*object.Builtin, len(builtins))
+	for name, b := range builtins {
+		table[name] = b
+	}
+	return table
+}
@@ -2,6 +2,11 @@ package evaluator
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
 	"time"
 
 	"bariq/object"
@@ -12,7 +17,7 @@ var builtins map[string]*object.Builtin
 func init() {
 	builtins = map[string]*object.Builtin{
 		"sleep": {
-			Fn: func(args ...object.Object) object.Object {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 				if len(args) != 1 {
 					return newError(
 						"wrong number of args, got %d, want 1",
@@ -22,16 +27,20 @@ func init() {
 				val, ok := args[0].(*object.Integer)
 				if !ok {
 					return newError(
-						"argument to `len` not supported, got %s",
-						args[1].Type(),
+						"argument to `sleep` not supported, got %s",
+						args[0].Type(),
 					)
 				}
-				time.Sleep(time.Second * time.Duration(val.Value))
-				return NULL
+				select {
+				case <-ctx.Clock.After(time.Second * time.Duration(val.Value)):
+					return NULL
+				case <-ctx.Done():
+					return ctx.Stopped()
+				}
 			},
 		},
 		"len": {
-			Fn: func(args ...object.Object) object.Object {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 				if len(args) != 1 {
 					return newError(
 						"wrong number of args, got %d, want 1",
@@ -48,16 +57,95 @@ func init() {
 				}
 			},
 		},
+		// converts a float, truncating it, or a string
+		"int": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError("wrong number of args for int, got %d, want 1", len(args))
+				}
+				switch arg := args[0].(type) {
+				case *object.Integer, *object.BigInt:
+					return arg
+				case *object.Float:
+					if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+						return newError("cannot convert %s to INTEGER", arg.Inspect())
+					}
+					i, _ := big.NewFloat(arg.Value).Int(nil)
+					return alloc(ctx, object.NewInt(i))
+				case *object.String:
+					i, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+					if !ok {
+						return newError("cannot convert %q to INTEGER", arg.Value)
+					}
+					return alloc(ctx, object.NewInt(i))
+				default:
+					return newError("argument to `int` not supported, got %s", args[0].Type())
+				}
+			},
+		},
+		"float": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError("wrong number of args for float, got %d, want 1", len(args))
+				}
+				switch arg := args[0].(type) {
+				case *object.Integer, *object.BigInt:
+					f, _ := toFloat(arg)
+					return &object.Float{Value: f}
+				case *object.Float:
+					return arg
+				case *object.String:
+					f, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+					if err != nil {
+						return newError("cannot convert %q to FLOAT", arg.Value)
+					}
+					return &object.Float{Value: f}
+				default:
+					return newError("argument to `float` not supported, got %s", args[0].Type())
+				}
+			},
+		},
 		"puts": {
-			Fn: func(args ...object.Object) object.Object {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 				for _, arg := range args {
-					fmt.Println(arg.Inspect())
+					fmt.Fprintln(ctx.Stdout, arg.Inspect())
 				}
 				return NULL
 			},
 		},
+		// reads a line from stdin, null once it is all read
+		"input": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 0 {
+					return newError(
+						"wrong number of args, got %d, want 0",
+						len(args),
+					)
+				}
+				line, err := ctx.ReadLine()
+				if err == io.EOF {
+					return NULL
+				}
+				if err != nil {
+					return newError("input: %s", err)
+				}
+				return alloc(ctx, &object.String{Value: line})
+			},
+		},
+		// milliseconds since the unix epoch
+		"now": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 0 {
+					return newError(
+						"wrong number of args, got %d, want 0",
+						len(args),
+					)
+				}
+				return &object.Integer{Value: ctx.Clock.Now().UnixMilli()}
+			},
+		},
 		"first": {
-			Fn: func(args ...object.Object) object.Object {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 				if len(args) != 1 {
 					return newError(
 						"wrong number of args, got %d, want 1",
@@ -79,7 +167,7 @@ func init() {
 			},
 		},
 		"last": {
-			Fn: func(args ...object.Object) object.Object {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 				if len(args) != 1 {
 					return newError(
 						"wrong number of args, got %d, want 1",
@@ -101,7 +189,7 @@ func init() {
 			},
 		},
 		"tail": {
-			Fn: func(args ...object.Object) object.Object {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 				if len(args) != 1 {
 					return newError(
 						"wrong number of args, got %d, want 1",
@@ -120,14 +208,14 @@ func init() {
 				if ln > 0 {
 					newElmnts := make([]object.Object, ln-1, ln-1)
 					copy(newElmnts, arr.Elements[1:ln])
-					return &object.Array{Elements: newElmnts}
+					return alloc(ctx, &object.Array{Elements: newElmnts, Frozen: arr.Frozen})
 				}
 				return NULL
 			},
 		},
 		// immutalbe
 		"push": {
-			Fn: func(args ...object.Object) object.Object {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 				if len(args) != 2 {
 					return newError(
 						"wrong number of args, got %d, want 2",
@@ -146,12 +234,13 @@ func init() {
 				newElmnts := make([]object.Object, ln+1, ln+1)
 				copy(newElmnts, arr.Elements)
 				newElmnts[ln] = args[1]
-				return &object.Array{Elements: newElmnts}
+				// the array made from a frozen one is frozen too
+				return alloc(ctx, &object.Array{Elements: newElmnts, Frozen: arr.Frozen})
 			},
 		},
 
 		"next": {
-			Fn: func(args ...object.Object) object.Object {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 				if len(args) != 1 {
 					return newError(
 						"wrong number of args for next, got %d, want 1",
@@ -161,44 +250,374 @@ func init() {
 
 				switch arg := args[0].(type) {
 				case *object.Generator:
-					var res object.Object
-					fmt.Printf("%+v is arg\n", arg)
-					fmt.Printf("i is %d , arg.stmts: %v\n", arg.Index, arg.Fn.Body.Stmts[arg.Index:])
-					for i, stmt := range arg.Fn.Body.Stmts[arg.Index:] {
-						fmt.Printf("stmt: %v\n", stmt)
-						res = Eval(stmt, arg.Env)
-						// fmt.Println("block: ", block.String(), env)
-						if res != nil {
-							// fmt.Printf("res: %v\n", res.Type())
-							rt := res.Type()
-							if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-								break
-							}
-							if rt == object.YIELD_VALUE_OBJ {
-								fmt.Printf("i is %d, idx is %d\n", i, arg.Index)
-								arg.Index += i + 1
-								arg.Value = res
-
-								if v, ok := arg.Value.(*object.YieldValue); ok {
-
-									fmt.Printf("v.Value: %v\n", v.Value)
-									return &object.Iteration{Done: false, Val: v.Value}
-								}
-								fmt.Println("not yield")
-							}
-						}
-						fmt.Printf("evaling %v is nil\n", stmt)
-
+					// a task of the iteration for async generators
+					if arg.Fn.IsAsync {
+						return nextTask(arg, ctx)
 					}
-
-					if v, ok := arg.Value.(*object.YieldValue); ok {
-						return &object.Iteration{Done: true, Val: v.Value}
-					}
-					return newError("not an yield value")
+					return resume(arg, ctx)
 				default:
 					return newError("argument to `next` not supported, got %s with %s", args[0].Type(), args[0].Inspect())
 				}
 			},
 		},
+		// a channel buffering up to its optional capacity
+		"chan": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) > 1 {
+					return newError(
+						"wrong number of args for chan, got %d, want 0 or 1",
+						len(args),
+					)
+				}
+				capacity := int64(0)
+				if len(args) == 1 {
+					n, ok := args[0].(*object.Integer)
+					if !ok || n.Value < 0 {
+						return newError("argument to `chan` must be a positive INTEGER, got %s", args[0].Inspect())
+					}
+					if n.Value > object.MaxChannelCap {
+						return newError("argument to `chan` must be at most %d, got %d", object.MaxChannelCap, n.Value)
+					}
+					capacity = n.Value
+				}
+				if err := ctx.Alloc(64 + 16*capacity); err != nil {
+					return err
+				}
+				ch, err := object.NewChannel(int(capacity))
+				if err != nil {
+					return err
+				}
+				return ch
+			},
+		},
+		// waits until the value is sent
+		"send": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 2 {
+					return newError(
+						"wrong number of args for send, got %d, want 2",
+						len(args),
+					)
+				}
+				ch, ok := args[0].(*object.Channel)
+				if !ok {
+					return newError("argument to `send` must be CHANNEL, got %s", args[0].Type())
+				}
+				if err := ch.Send(ctx, args[1]); err != nil {
+					return err
+				}
+				return NULL
+			},
+		},
+		// waits for a value, null once the channel is closed
+		"recv": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError(
+						"wrong number of args for recv, got %d, want 1",
+						len(args),
+					)
+				}
+				ch, ok := args[0].(*object.Channel)
+				if !ok {
+					return newError("argument to `recv` must be CHANNEL, got %s", args[0].Type())
+				}
+				val, _, err := ch.Recv(ctx)
+				if err != nil {
+					return err
+				}
+				return val
+			},
+		},
+		"close": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError(
+						"wrong number of args for close, got %d, want 1",
+						len(args),
+					)
+				}
+				ch, ok := args[0].(*object.Channel)
+				if !ok {
+					return newError("argument to `close` must be CHANNEL, got %s", args[0].Type())
+				}
+				if err := ch.Close(); err != nil {
+					return err
+				}
+				return NULL
+			},
+		},
+		// the number of async calls running at once, setting
+		// it to the given number returns the previous one
+		"concurrency": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) > 1 {
+					return newError(
+						"wrong number of args for concurrency, got %d, want 0 or 1",
+						len(args),
+					)
+				}
+				s := ctx.Scheduler()
+				prev := &object.Integer{Value: int64(s.Workers())}
+				if len(args) == 1 {
+					n, ok := args[0].(*object.Integer)
+					if !ok || n.Value < 1 {
+						return newError("argument to `concurrency` must be an INTEGER above 0, got %s", args[0].Inspect())
+					}
+					s.SetWorkers(int(n.Value))
+				}
+				return prev
+			},
+		},
+		// stops a task, awaiting it then returns an error
+		"cancel": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError(
+						"wrong number of args for cancel, got %d, want 1",
+						len(args),
+					)
+				}
+				task, ok := args[0].(*object.Task)
+				if !ok {
+					return newError("argument to `cancel` not supported, got %s", args[0].Type())
+				}
+				tracef(ctx, TraceAsync, "cancel")
+				task.Spawned.Cancel()
+				return NULL
+			},
+		},
+		// the values of the tasks in order, or the first error
+		"awaitAll": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				tasks, errObj := taskArgs("awaitAll", args)
+				if errObj != nil {
+					return errObj
+				}
+				res := make([]object.Object, len(tasks))
+				return waitTasks(ctx, tasks, func(s settled) object.Object {
+					if isError(s.val) {
+						return s.val
+					}
+					res[s.index] = s.val
+					return nil
+				}, func() object.Object { return alloc(ctx, &object.Array{Elements: res}) })
+			},
+		},
+		// the value of the first task to succeed
+		"awaitAny": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				tasks, errObj := taskArgs("awaitAny", args)
+				if errObj != nil {
+					return errObj
+				}
+				if len(tasks) == 0 {
+					return newError("awaitAny: no tasks")
+				}
+				var last object.Object
+				return waitTasks(ctx, tasks, func(s settled) object.Object {
+					if isError(s.val) {
+						last = s.val
+						return nil
+					}
+					return s.val
+				}, func() object.Object {
+					return newError("awaitAny: all tasks failed, last: %s", last.(*object.Error).Message)
+				})
+			},
+		},
+		// the value or error of the first task to finish,
+		// the others are cancelled
+		"race": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				tasks, errObj := taskArgs("race", args)
+				if errObj != nil {
+					return errObj
+				}
+				if len(tasks) == 0 {
+					return newError("race: no tasks")
+				}
+				return waitTasks(ctx, tasks, func(s settled) object.Object {
+					for i, el := range tasks {
+						if t, ok := el.(*object.Task); ok && i != s.index {
+							t.Spawned.Cancel()
+						}
+					}
+					return s.val
+				}, nil)
+			},
+		},
+		// a hash per task, {"status": "fulfilled", "value": v}
+		// or {"status": "rejected", "error": message}
+		"allSettled": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				tasks, errObj := taskArgs("allSettled", args)
+				if errObj != nil {
+					return errObj
+				}
+				res := make([]object.Object, len(tasks))
+				return waitTasks(ctx, tasks, func(s settled) object.Object {
+					if errObj, ok := s.val.(*object.Error); ok {
+						res[s.index] = settledHash("rejected", "error", &object.String{Value: errObj.Message})
+					} else {
+						res[s.index] = settledHash("fulfilled", "value", s.val)
+					}
+					return nil
+				}, func() object.Object { return alloc(ctx, &object.Array{Elements: res}) })
+			},
+		},
+		// a frozen copy of an array or a hash, their
+		// elements are frozen too
+		"freeze": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError("wrong number of args for freeze, got %d, want 1", len(args))
+				}
+				return freeze(ctx, args[0])
+			},
+		},
+		"isFrozen": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) != 1 {
+					return newError("wrong number of args for isFrozen, got %d, want 1", len(args))
+				}
+				return toBoolObj(isFrozen(args[0]))
+			},
+		},
+		// calls an async function with the rest of the args,
+		// its task is cancelled after the given milliseconds
+		"withTimeout": {
+			Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+				if len(args) < 2 {
+					return newError(
+						"wrong number of args for withTimeout, got %d, want 2",
+						len(args),
+					)
+				}
+				ms, ok := args[0].(*object.Integer)
+				if !ok {
+					return newError("first argument to `withTimeout` must be INTEGER, got %s", args[0].Type())
+				}
+				fn, ok := args[1].(*object.Function)
+				if !ok || !fn.IsAsync {
+					return newError("second argument to `withTimeout` must be an async function, got %s", args[1].Inspect())
+				}
+				params := args[2:]
+				if len(params) < len(fn.Parameters) {
+					return newError(
+						"wrong number of args for %s, got %d, want %d",
+						funcName(fn), len(params), len(fn.Parameters),
+					)
+				}
+				if err := ctx.Alloc(envSize(len(fn.Parameters))); err != nil {
+					return err
+				}
+				return spawnTask(fn, extendedStaticEnv(fn, params), ctx, time.Duration(ms.Value)*time.Millisecond)
+			},
+		},
+	}
+}
+
+func isFrozen(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Array:
+		return obj.Frozen
+	case *object.Hash:
+		return obj.Frozen
+	}
+	return false
+}
+
+// freeze copies the arrays and hashes of obj that aren't frozen yet,
+// so the references to the original ones can still change them
+func freeze(ctx *object.Context, obj object.Object) object.Object {
+	if isFrozen(obj) {
+		return obj
+	}
+	switch obj := obj.(type) {
+	case *object.Array:
+		elements := make([]object.Object, len(obj.Elements))
+		for i, el := range obj.Elements {
+			elements[i] = freeze(ctx, el)
+			if isError(elements[i]) {
+				return elements[i]
+			}
+		}
+		return alloc(ctx, &object.Array{Elements: elements, Frozen: true})
+	case *object.Hash:
+		pairs := make(map[object.HashKey]object.HashPair, len(obj.Pairs))
+		for k, pair := range obj.Pairs {
+			val := freeze(ctx, pair.Value)
+			if isError(val) {
+				return val
+			}
+			pairs[k] = object.HashPair{Key: pair.Key, Value: val}
+		}
+		return alloc(ctx, &object.Hash{Pairs: pairs, Frozen: true})
+	}
+	return obj
+}
+
+// taskArgs checks the args of a task combinator, an array
+// of tasks, its other elements count as finished tasks
+func taskArgs(name string, args []object.Object) ([]object.Object, *object.Error) {
+	if len(args) != 1 {
+		return nil, newError("wrong number of args for %s, got %d, want 1", name, len(args))
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, newError("argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	return arr.Elements, nil
+}
+
+// settled is the value of the task at index, an error if it failed
+type settled struct {
+	index int
+	val   object.Object
+}
+
+// waitTasks awaits the tasks concurrently, passing each result to
+// handle as it comes, until handle returns a result. done gives the
+// result once every task is handled.
+func waitTasks(
+	ctx *object.Context,
+	tasks []object.Object,
+	handle func(settled) object.Object,
+	done func() object.Object,
+) object.Object {
+	ch := make(chan settled, len(tasks))
+	// elements that aren't tasks come first, in order
+	for i, el := range tasks {
+		if _, ok := el.(*object.Task); !ok {
+			ch <- settled{index: i, val: el}
+		}
+	}
+	for i, el := range tasks {
+		if t, ok := el.(*object.Task); ok {
+			go func(i int, t *object.Task) {
+				ch <- settled{index: i, val: awaitTask(t)}
+			}(i, t)
+		}
+	}
+	for range tasks {
+		select {
+		case s := <-ch:
+			if res := handle(s); res != nil {
+				return res
+			}
+		case <-ctx.Done():
+			return ctx.Stopped()
+		}
+	}
+	return done()
+}
+
+func settledHash(status, key string, val object.Object) *object.Hash {
+	pairs := map[object.HashKey]object.HashPair{}
+	for k, v := range map[string]object.Object{"status": &object.String{Value: status}, key: val} {
+		s := &object.String{Value: k}
+		pairs[s.HashKey()] = object.HashPair{Key: s, Value: v}
 	}
+	return &object.Hash{Pairs: pairs}
 }
@@ -2,17 +2,25 @@ package evaluator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"bariq/ast"
 	"bariq/object"
 	"bariq/sched"
+	"bariq/token"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
 func isError(obj object.Object) bool {
@@ -22,80 +30,132 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-func Eval(node ast.Node, env *object.Env) object.Object {
+// interrupts tells if obj ends the evaluation of the expression it
+// is part of, ex: an error, or a break in an if used as an operand
+func interrupts(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+		return true
+	}
+	return false
+}
+
+func Eval(node ast.Node, env *object.Env, ctx *object.Context) object.Object {
+	if err := ctx.Step(); err != nil {
+		return at(node, err)
+	}
 	switch node := node.(type) {
 	// Stmts
 	case *ast.Program:
-		return evalProgram(node.Stmts, env)
+		return evalProgram(node.Stmts, env, ctx)
 	// diff between expstmt and retstmt is HERE
 	case *ast.ExprStmt:
-		return Eval(node.Expr, env)
+		return Eval(node.Expr, env, ctx)
 	case *ast.BlockStmt:
-		return evalBlockStmt(node, env)
+		return evalBlockStmt(node, env, ctx)
 	case *ast.ReturnStmt:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := Eval(node.Value, env, ctx)
+		if interrupts(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStmt:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := Eval(node.Value, env, ctx)
+		if interrupts(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if fn, ok := val.(*object.Function); ok && fn.Name == "" {
+			fn.Name = node.Name.Value
+		}
+		if !env.Declare(node.Name.Value, val, node, node.Const) {
+			return at(node, newError("cannot redeclare const %s", node.Name.Value))
+		}
 		// fmt.Println("Env of: ", node.Name.Value, env)
 	// Exprs
 
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return at(node, alloc(ctx, &object.String{Value: node.Value}))
 	case *ast.IntLiteral:
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.ArrayLiteral:
-		elms := evalExprs(node.Elmnts, env)
-		if len(elms) == 1 && isError(elms[0]) {
+		elms := evalExprs(node.Elmnts, env, ctx)
+		if len(elms) == 1 && interrupts(elms[0]) {
 			return elms[0]
 		}
-		return &object.Array{Elements: elms}
+		return at(node, alloc(ctx, &object.Array{Elements: elms}))
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return at(node, alloc(ctx, evalHashLiteral(node, env, ctx)))
 	case *ast.Boolean:
 		// why to create an object every time
 		// where you can just declare two values
 		// and use them every time you need them
 		return toBoolObj(node.Value)
 	case *ast.PrefixExpr:
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := Eval(node.Right, env, ctx)
+		if interrupts(right) {
 			return right
 		}
-		return evalPrefixExpr(node.Operator, right)
+		return at(node, evalPrefixExpr(node.Operator, right))
 	case *ast.InfixExpr:
-		right := Eval(node.Right, env)
-		if isError(right) {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpr(node, env, ctx)
+		}
+		right := Eval(node.Right, env, ctx)
+		if interrupts(right) {
 			return right
 		}
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := Eval(node.Left, env, ctx)
+		if interrupts(left) {
 			return left
 		}
-		return evalInfixExpr(node.Operator, left, right)
+		return at(node, alloc(ctx, evalInfixExpr(node.Operator, left, right)))
+	case *ast.AssignExpr:
+		return evalAssignExpr(node, env, ctx)
 	case *ast.YieldExpr:
-		fmt.Printf("yield node: %v\n", node)
-		val := Eval(node.Arg, env)
-		if isError(val) {
+		val := Eval(node.Arg, env, ctx)
+		if interrupts(val) {
 			return val
 		}
-		return &object.YieldValue{Value: val}
+		// suspends until the generator is resumed,
+		// the yield then evaluates to the yielded value
+		gen := env.Generator()
+		if traceOn(ctx, TraceGen) {
+			tracef(ctx, TraceGen, "yield %s at %s", inspect(val), node.Pos())
+		}
+		if gen == nil {
+			return at(node, newError("yield outside of a generator"))
+		}
+		if err := gen.Yield(val); err != nil {
+			return at(node, err)
+		}
+		return val
+
+	case *ast.ImportExpr:
+		return at(node, evalImportExpr(node, ctx))
 
 	case *ast.AwaitExpr:
-		return evalAwaitExpr(node, env)
-		// return evalIfExpr(node, env)
+		return evalAwaitExpr(node, env, ctx)
+		// return evalIfExpr(node, env, ctx)
 	case *ast.IfExpr:
-		return evalIfExpr(node, env)
+		return evalIfExpr(node, env, ctx)
+	case *ast.SelectExpr:
+		return evalSelectExpr(node, env, ctx)
+	case *ast.ForInExpr:
+		return evalForInExpr(node, env, ctx)
+	case *ast.WhileExpr:
+		return evalWhileExpr(node, env, ctx)
+	case *ast.BreakStmt:
+		return object.BREAK
+	case *ast.ContinueStmt:
+		return object.CONTINUE
 
 	case *ast.Ident:
-		return evalIdent(node, env)
+		return at(node, evalIdent(node, env, ctx))
 
 	case *ast.FunctionLiteral:
 		isAsync := node.Async
@@ -106,13 +166,22 @@ func Eval(node ast.Node, env *object.Env) object.Object {
 		// INFO: when the function declared, the env is assigned
 		return &object.Function{Parameters: params, Env: env, Body: body, IsAsync: isAsync, IsGen: isGen}
 
+	case *ast.MacroLiteral:
+		return at(node, newError("macros can only be defined by a top level let"))
+
 	case *ast.CallExpr:
-		function := Eval(node.Function, env)
-		if isError(function) {
+		if ident, ok := node.Function.(*ast.Ident); ok && ident.Value == "quote" {
+			if len(node.Args) != 1 {
+				return at(node, newError("wrong number of args for quote, got %d, want 1", len(node.Args)))
+			}
+			return quote(node.Args[0], env, ctx)
+		}
+		function := Eval(node.Function, env, ctx)
+		if interrupts(function) {
 			return function
 		}
-		args := evalExprs(node.Args, env)
-		if len(args) == 1 && isError(args[0]) {
+		args := evalExprs(node.Args, env, ctx)
+		if len(args) == 1 && interrupts(args[0]) {
 			return args[0]
 		}
 		// NOTE: to make this dynamic scope, instead of usnig function env
@@ -122,35 +191,49 @@ func Eval(node ast.Node, env *object.Env) object.Object {
 		// TODO: check matching args
 		// fmt.Printf("len(args): %v\n", len(node.Args))
 
-		return applyFunc(function, args)
+		name := callName(node, function)
+		if traceOn(ctx, TraceEval) {
+			tracef(ctx, TraceEval, "call %s(%s) at %s", name, inspectAll(args), node.Pos())
+		}
+		res := at(node, applyFunc(function, args, ctx))
+		if traceOn(ctx, TraceEval) {
+			tracef(ctx, TraceEval, "%s returned %s", name, inspect(res))
+		}
+		if err, ok := res.(*object.Error); ok {
+			err.Stack = append(err.Stack, object.Frame{
+				Name: name,
+				Pos:  node.Pos(),
+			})
+		}
+		return res
 
 	case *ast.IndexExpr:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := Eval(node.Left, env, ctx)
+		if interrupts(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
-		if isError(index) {
+		index := Eval(node.Index, env, ctx)
+		if interrupts(index) {
 			return index
 		}
-		return evalIndexExpr(left, index)
+		return at(node, evalIndexExpr(left, index))
 	}
 	return nil
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Env) object.Object {
+func evalHashLiteral(node *ast.HashLiteral, env *object.Env, ctx *object.Context) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 	for kn, vn := range node.Pairs {
-		k := Eval(kn, env)
-		if isError(k) {
+		k := Eval(kn, env, ctx)
+		if interrupts(k) {
 			return k
 		}
 		hashKey, ok := k.(object.Hashable)
 		if !ok {
 			return newError("unusable as hashKey: %s", k.Type())
 		}
-		val := Eval(vn, env)
-		if isError(val) {
+		val := Eval(vn, env, ctx)
+		if interrupts(val) {
 			return val
 		}
 		hashed := hashKey.HashKey()
@@ -163,47 +246,340 @@ func applyFunc(
 	// env *object.Env,
 	fn object.Object,
 	args []object.Object,
+	ctx *object.Context,
 ) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) < len(fn.Parameters) {
+			return newError(
+				"wrong number of args for %s, got %d, want %d",
+				funcName(fn), len(args), len(fn.Parameters),
+			)
+		}
+		if err := ctx.Alloc(envSize(len(fn.Parameters))); err != nil {
+			return err
+		}
 		// fmt.Printf("fn being applied %+v, env_addr: %p\n", fn, fn.Env)
 		// extendedEnv := extendedDynamicEnv(env, fn, args)
 		extendedEnv := extendedStaticEnv(fn, args)
+		// async generators are resumed by tasks, see nextTask
+		if fn.IsGen {
+			return newGenerator(fn, extendedEnv, ctx)
+		}
 		if fn.IsAsync {
-			fmt.Printf("fn.IsAsync: %v\n", fn.IsAsync)
-			task := sched.Spawn(func(_ context.Context) (object.Object, error) {
-				if fn.IsGen {
-					fmt.Printf("fn.IsGen: %v\n", fn.IsGen)
-					return &object.Generator{
-						Fn:  fn,
-						Env: extendedEnv,
-					}, nil
-				}
-				evaluated := Eval(fn.Body, extendedEnv)
-				return unwrapReturnValue(evaluated), nil
-			})
-			return &object.Task{
-				Spawned: task,
-			}
+			return spawnTask(fn, extendedEnv, ctx, 0)
 		}
-
-		fmt.Printf("fn.IsGen: %v\n", fn.IsGen)
-		if fn.IsGen {
-			fmt.Printf("fn.IsGen: %v\n", fn.IsGen)
-			return &object.Generator{
-				Fn:  fn,
-				Env: extendedEnv,
-			}
+		if err := ctx.Enter(); err != nil {
+			return err
 		}
-		evaluated := Eval(fn.Body, extendedEnv)
+		defer ctx.Leave()
+		evaluated := Eval(fn.Body, extendedEnv, ctx)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		return fn.Fn(ctx, args...)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// spawnTask queues the body of the async fn in env on the
+// scheduler of ctx, the task is cancelled after timeout if it isn't 0
+// or when ctx is done
+func spawnTask(fn *object.Function, env *object.Env, ctx *object.Context, timeout time.Duration) *object.Task {
+	tracef(ctx, TraceAsync, "spawn %s", funcName(fn))
+	taskCtx := ctx.Fork()
+	run := func(c context.Context) (object.Object, error) {
+		// statements and sleep stop once c is done
+		taskCtx.Ctx = c
+		if fn.IsGen {
+			return newGenerator(fn, env, taskCtx), nil
+		}
+		// a task awaiting the task it spawned nests on the same stack
+		if err := taskCtx.Enter(); err != nil {
+			return nil, err
+		}
+		defer taskCtx.Leave()
+		evaluated := unwrapReturnValue(Eval(fn.Body, env, taskCtx))
+		if traceOn(ctx, TraceAsync) {
+			tracef(ctx, TraceAsync, "%s done: %s", funcName(fn), inspect(evaluated))
+		}
+		if errObj, ok := evaluated.(*object.Error); ok {
+			// rejects the task
+			return nil, errObj
+		}
+		return evaluated, nil
+	}
+	parent := ctx.Ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	if timeout > 0 {
+		return &object.Task{Spawned: sched.SubmitWithTimeout(ctx.Scheduler(), parent, run, timeout)}
+	}
+	return &object.Task{Spawned: sched.Submit(ctx.Scheduler(), parent, run)}
+}
+
+// Apply calls fn, a function or a builtin, with args,
+// ex: when a host calls a function defined by a script
+func Apply(fn object.Object, args []object.Object, ctx *object.Context) object.Object {
+	return applyFunc(fn, args, ctx)
+}
+
+// newGenerator returns a generator running the body of fn in env,
+// its final value is the value of the body like for a call
+func newGenerator(fn *object.Function, env *object.Env, ctx *object.Context) *object.Generator {
+	tracef(ctx, TraceGen, "new generator %s", funcName(fn))
+	// the body runs on its own goroutine
+	ctx = ctx.Fork()
+	return object.NewGenerator(fn, env, func(env *object.Env) object.Object {
+		if res := unwrapReturnValue(Eval(fn.Body, env, ctx)); res != nil {
+			return res
+		}
+		return NULL
+	})
+}
+
+// resume runs gen until its next yield
+func resume(gen *object.Generator, ctx *object.Context) object.Object {
+	tracef(ctx, TraceGen, "resume %s", funcName(gen.Fn))
+	res := gen.Next()
+	if it, ok := res.(*object.Iteration); ok && it.Done {
+		if traceOn(ctx, TraceGen) {
+			tracef(ctx, TraceGen, "%s done: %s", funcName(gen.Fn), inspect(it.Val))
+		}
+	}
+	return res
+}
+
+// nextTask resumes the async generator gen in a task, the tasks of
+// gen resume it in the order they were made
+func nextTask(gen *object.Generator, ctx *object.Context) *object.Task {
+	wait, done := gen.Turn()
+	parent := ctx.Ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	return &object.Task{Spawned: sched.Submit(ctx.Scheduler(), parent, func(c context.Context) (object.Object, error) {
+		defer done()
+		select {
+		case <-wait:
+		case <-c.Done():
+			return nil, c.Err()
+		}
+		res := resume(gen, ctx)
+		if errObj, ok := res.(*object.Error); ok {
+			return nil, errObj
+		}
+		return res, nil
+	})}
+}
+
+// bind sets a name bound by a loop or a select case,
+// it is an error when the name is a const of env
+func bind(env *object.Env, name *ast.Ident, val object.Object) object.Object {
+	if !env.Declare(name.Value, val, name, false) {
+		return at(name, newError("cannot assign to const %s", name.Value))
+	}
+	return nil
+}
+
+func evalWhileExpr(we *ast.WhileExpr, env *object.Env, ctx *object.Context) object.Object {
+	for {
+		condition := Eval(we.Condition, env, ctx)
+		if interrupts(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+		if res, done := endsLoop(Eval(we.Body, env, ctx)); done {
+			return res
+		}
+	}
+}
+
+func evalForInExpr(fe *ast.ForInExpr, env *object.Env, ctx *object.Context) object.Object {
+	iterable := Eval(fe.Iterable, env, ctx)
+	if interrupts(iterable) {
+		return iterable
+	}
+	// body runs the body for a key and its value, done is set
+	// when the loop ends with res
+	body := func(key, val object.Object) (res object.Object, done bool) {
+		if t, ok := val.(*object.Task); ok && fe.Await {
+			if val = awaitTask(t); isError(val) {
+				return at(fe, val), true
+			}
+		}
+		if fe.Key != nil {
+			if err := bind(env, fe.Key, key); err != nil {
+				return err, true
+			}
+		}
+		if err := bind(env, fe.Name, val); err != nil {
+			return err, true
+		}
+		return endsLoop(Eval(fe.Body, env, ctx))
+	}
+	switch it := iterable.(type) {
+	case *object.Array:
+		for i, el := range it.Elements {
+			if res, done := body(&object.Integer{Value: int64(i)}, el); done {
+				return res
+			}
+		}
+	case *object.String:
+		for i, r := range []rune(it.Value) {
+			if res, done := body(&object.Integer{Value: int64(i)}, &object.String{Value: string(r)}); done {
+				return res
+			}
+		}
+	case *object.Hash:
+		for _, pair := range sortedPairs(it) {
+			key, val := pair.Key, pair.Value
+			if fe.Key == nil {
+				// a single name gets the keys
+				val = key
+			}
+			if res, done := body(key, val); done {
+				return res
+			}
+		}
+	case *object.Generator:
+		if it.Fn.IsAsync && !fe.Await {
+			return at(fe.Iterable, newError("async generators are iterated with for await"))
+		}
+		for i := int64(0); ; i++ {
+			var res object.Object
+			if it.Fn.IsAsync {
+				res = awaitTask(nextTask(it, ctx))
+			} else {
+				res = resume(it, ctx)
+			}
+			if isError(res) {
+				return at(fe.Iterable, res)
+			}
+			iter := res.(*object.Iteration)
+			if iter.Done {
+				return NULL
+			}
+			if res, done := body(&object.Integer{Value: i}, iter.Val); done {
+				// lets the suspended body end
+				it.Close(NULL)
+				return res
+			}
+		}
+	default:
+		return at(fe.Iterable, newError("cannot iterate over %s", iterable.Type()))
+	}
+	return NULL
+}
+
+// endsLoop tells if a loop ends after its body evaluated to res,
+// returning the value of the loop then
+func endsLoop(res object.Object) (object.Object, bool) {
+	switch res.(type) {
+	case *object.Break:
+		return NULL, true
+	case *object.ReturnValue, *object.Error:
+		return res, true
+	}
+	return nil, false
+}
+
+// sortedPairs returns the pairs of h by key, integers and strings
+// in their natural order and false before true
+func sortedPairs(h *object.Hash) []object.HashPair {
+	pairs := make([]object.HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i].Key, pairs[j].Key
+		if x, ok := object.ToBig(a); ok {
+			if y, ok := object.ToBig(b); ok {
+				return x.Cmp(y) < 0
+			}
+		}
+		if x, ok := toFloat(a); ok {
+			if y, ok := toFloat(b); ok {
+				return x < y
+			}
+		}
+		if a.Type() != b.Type() {
+			return a.Type() < b.Type()
+		}
+		switch a := a.(type) {
+		case *object.String:
+			return a.Value < b.(*object.String).Value
+		case *object.Boolean:
+			return !a.Value && b.(*object.Boolean).Value
+		}
+		return false
+	})
+	return pairs
+}
+
+// alloc counts the approximate size of obj, a value just
+// created by the program, returning an error over the limit
+func alloc(ctx *object.Context, obj object.Object) object.Object {
+	var n int64
+	switch obj := obj.(type) {
+	case *object.String:
+		n = 16 + int64(len(obj.Value))
+	case *object.Array:
+		n = 24 + 16*int64(len(obj.Elements))
+	case *object.Hash:
+		n = 48 + 64*int64(len(obj.Pairs))
+	case *object.BigInt:
+		n = 32 + 8*int64(len(obj.Value.Bits()))
+	default:
+		return obj
+	}
+	if err := ctx.Alloc(n); err != nil {
+		return err
+	}
+	return obj
+}
+
+// envSize is the approximate size of the env of a call
+func envSize(params int) int64 { return 64 + 32*int64(params) }
+
+// funcName is the name of fn
+func funcName(fn *object.Function) string {
+	if fn.Name != "" {
+		return fn.Name
+	}
+	return "<anonymous>"
+}
+
+// inspect is obj.Inspect for traces, obj may be nil
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return NULL.Inspect()
+	}
+	return obj.Inspect()
+}
+
+func inspectAll(objs []object.Object) string {
+	strs := make([]string, len(objs))
+	for i, o := range objs {
+		strs[i] = inspect(o)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// callName is the name shown for a call in stack traces
+func callName(node *ast.CallExpr, fn object.Object) string {
+	if fn, ok := fn.(*object.Function); ok && fn.Name != "" {
+		return fn.Name
+	}
+	if ident, ok := node.Function.(*ast.Ident); ok {
+		return ident.Value
+	}
+	return "<anonymous>"
+}
+
 func extendedDynamicEnv(
 	oldEnv *object.Env,
 	fn *object.Function,
@@ -244,11 +620,11 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func evalExprs(exprs []ast.Expr, env *object.Env) []object.Object {
+func evalExprs(exprs []ast.Expr, env *object.Env, ctx *object.Context) []object.Object {
 	var res []object.Object
 	for _, e := range exprs {
-		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		evaluated := Eval(e, env, ctx)
+		if interrupts(evaluated) {
 			return []object.Object{evaluated}
 		}
 		res = append(res, evaluated)
@@ -262,6 +638,8 @@ func evalIndexExpr(left, index object.Object) object.Object {
 		return evalArrayIndexExpr(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpr(left, index)
+	case left.Type() == object.MODULE_OBJ:
+		return evalModuleIndexExpr(left, index)
 	default:
 		return newError(`index operator not supported: %s `, left.Type())
 	}
@@ -282,7 +660,12 @@ func evalHashIndexExpr(hash, index object.Object) object.Object {
 
 func evalArrayIndexExpr(array, index object.Object) object.Object {
 	arrObj := array.(*object.Array)
-	idx := index.(*object.Integer).Value
+	i, ok := index.(*object.Integer)
+	if !ok {
+		// a BigInt is out of range
+		return NULL
+	}
+	idx := i.Value
 	max := int64(len(arrObj.Elements) - 1)
 	if idx < 0 || idx > max {
 		return NULL
@@ -290,14 +673,11 @@ func evalArrayIndexExpr(array, index object.Object) object.Object {
 	return arrObj.Elements[idx]
 }
 
-func evalIdent(node *ast.Ident, env *object.Env) object.Object {
+func evalIdent(node *ast.Ident, env *object.Env, ctx *object.Context) object.Object {
 	if val, ok := env.Get(node.Value); ok {
-		if val.Type() == object.GEN_OBJ {
-			fmt.Printf("gen: %+v\n", val)
-		}
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := LookupBuiltin(node.Value, ctx); ok {
 		// fmt.Println("found", node.Value)
 		return builtin
 	}
@@ -308,16 +688,29 @@ func newError(format string, a ...any) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func evalBlockStmt(block *ast.BlockStmt, env *object.Env) object.Object {
+// at sets the position of an error that doesn't have one yet to
+// node's position, so errors point to the innermost node raising them
+func at(node ast.Node, obj object.Object) object.Object {
+	return atPos(node.Pos(), obj)
+}
+
+func atPos(pos token.Position, obj object.Object) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = pos
+	}
+	return obj
+}
+
+func evalBlockStmt(block *ast.BlockStmt, env *object.Env, ctx *object.Context) object.Object {
 	var res object.Object
 	// res will be the last evaluated stmt
 	for _, stmt := range block.Stmts {
 
-		res = Eval(stmt, env)
+		res = Eval(stmt, env, ctx)
 		// fmt.Println("block: ", block.String(), env)
 		if res != nil {
-			rt := res.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			switch res.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return res
 			}
 		}
@@ -325,34 +718,162 @@ func evalBlockStmt(block *ast.BlockStmt, env *object.Env) object.Object {
 	return res
 }
 
-func evalAwaitExpr(a *ast.AwaitExpr, env *object.Env) object.Object {
-	fmt.Println("Entered Await")
-	evaluated := Eval(a.Arg, env)
+func evalAwaitExpr(a *ast.AwaitExpr, env *object.Env, ctx *object.Context) object.Object {
+	evaluated := Eval(a.Arg, env, ctx)
 	t, ok := evaluated.(*object.Task)
 	if !ok {
 		return evaluated
 	}
+	tracef(ctx, TraceAsync, "await at %s", a.Pos())
+	evalT := awaitTask(t)
+	if traceOn(ctx, TraceAsync) {
+		tracef(ctx, TraceAsync, "awaited at %s: %s", a.Pos(), inspect(evalT))
+	}
+	if errObj, ok := evalT.(*object.Error); ok && errObj.Pos.IsValid() {
+		// raised by the task, its traceback goes on from the await site
+		errObj.Stack = append(errObj.Stack, object.Frame{Name: "await", Pos: a.Pos()})
+	}
+	return at(a, evalT)
+}
+
+// awaitTask waits for the value of t, a copy of the error it
+// failed with, with its position and stack, or an error if it
+// was stopped before finishing
+func awaitTask(t *object.Task) object.Object {
 	evalT, err := t.Spawned.Await()
-	if err != nil {
+	var errObj *object.Error
+	if errors.As(err, &errObj) {
+		stop := t.Spawned.Err()
+		if stop == nil || !errors.Is(errObj, stop) {
+			// copied as it unwinds through the awaiting calls,
+			// and a task can be awaited several times
+			reraised := *errObj
+			reraised.Stack = append([]object.Frame(nil), errObj.Stack...)
+			return &reraised
+		}
+		// the task stopped because it was cancelled
+		err = stop
+	}
+	var panicErr *sched.PanicError
+	switch {
+	case errors.As(err, &panicErr):
+		return &object.Error{Message: "async task panicked: " + fmt.Sprint(panicErr.Value), Err: err}
+	case errors.Is(err, context.Canceled):
+		return &object.Error{Message: "await: task cancelled", Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &object.Error{Message: "await: task timed out", Err: err}
+	case err != nil:
 		return newError("error has occured while awaiting - %+v", err)
+	case evalT == nil:
+		return NULL
 	}
 	return evalT
 }
 
-func evalIfExpr(ie *ast.IfExpr, env *object.Env) object.Object {
-	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+// selectTarget is what a case of reflect.Select stands for,
+// closed is set for the cases waiting for ch to be closed
+type selectTarget struct {
+	c      *ast.SelectCase
+	ch     *object.Channel
+	closed bool
+}
+
+func evalSelectExpr(se *ast.SelectExpr, env *object.Env, ctx *object.Context) object.Object {
+	cases := []reflect.SelectCase{}
+	targets := []selectTarget{}
+	for _, c := range se.Cases {
+		args := evalExprs(c.Args, env, ctx)
+		if len(args) == 1 && interrupts(args[0]) {
+			return args[0]
+		}
+		switch c.Kind {
+		case "recv", "send":
+			ch, ok := args[0].(*object.Channel)
+			if !ok {
+				return at(c.Args[0], newError("argument to `%s` must be CHANNEL, got %s", c.Kind, args[0].Type()))
+			}
+			sc := reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.Chan())}
+			if c.Kind == "send" {
+				sc = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch.Chan()), Send: reflect.ValueOf(args[1])}
+			}
+			cases = append(cases, sc, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.Closed())})
+			targets = append(targets, selectTarget{c: c, ch: ch}, selectTarget{c: c, ch: ch, closed: true})
+		case "timeout":
+			ms, ok := args[0].(*object.Integer)
+			if !ok {
+				return at(c.Args[0], newError("argument to `timeout` must be INTEGER, got %s", args[0].Type()))
+			}
+			after := ctx.Clock.After(time.Duration(ms.Value) * time.Millisecond)
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(after)})
+			targets = append(targets, selectTarget{c: c})
+		}
+	}
+	if se.Default != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+
+	chosen, recv, _ := reflect.Select(cases)
+	if chosen >= len(targets) {
+		if chosen == len(cases)-1 {
+			return at(se, ctx.Stopped())
+		}
+		return Eval(se.Default, env, ctx)
+	}
+	t := targets[chosen]
+	switch {
+	case t.c.Kind == "recv":
+		var val object.Object
+		var ok bool
+		if t.closed {
+			val, ok = t.ch.TryRecv()
+		} else {
+			val, ok = recv.Interface().(object.Object), true
+		}
+		if t.c.Value != nil {
+			if err := bind(env, t.c.Value, val); err != nil {
+				return err
+			}
+		}
+		if t.c.Ok != nil {
+			if err := bind(env, t.c.Ok, toBoolObj(ok)); err != nil {
+				return err
+			}
+		}
+	case t.c.Kind == "send" && t.closed:
+		return at(t.c.Args[0], newError("send on closed channel"))
+	}
+	return Eval(t.c.Body, env, ctx)
+}
+
+func evalIfExpr(ie *ast.IfExpr, env *object.Env, ctx *object.Context) object.Object {
+	condition := Eval(ie.Condition, env, ctx)
+	if interrupts(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return Eval(ie.Consequence, env, ctx)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return Eval(ie.Alternative, env, ctx)
 	} else {
 		return NULL
 	}
 }
 
+// evalLogicalExpr evaluates the right operand of && and || only
+// when the left one doesn't decide the result, the result is the
+// operand that decided it.
+func evalLogicalExpr(node *ast.InfixExpr, env *object.Env, ctx *object.Context) object.Object {
+	left := Eval(node.Left, env, ctx)
+	if interrupts(left) {
+		return left
+	}
+	if isTruthy(left) == (node.Operator == "||") {
+		return left
+	}
+	return Eval(node.Right, env, ctx)
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -376,6 +897,9 @@ func evalInfixExpr(
 		return evalStringInfixExpr(op, left, right)
 	case left.Type() == object.INT_OBJ && right.Type() == object.INT_OBJ:
 		return evalIntegerInfixExpr(op, left, right)
+	case isNumber(left) && isNumber(right):
+		// an integer mixed with a float is converted to a float
+		return evalFloatInfixExpr(op, left, right)
 	case op == "==":
 		// use pointer comparison to compare between bools
 		return toBoolObj(left == right)
@@ -413,30 +937,106 @@ func evalPrefixExpr(op string, right object.Object) object.Object {
 	}
 }
 
+// maxPowBits bounds the size of the result of **, so
+// a single operation can't take all the memory
+const maxPowBits = 1 << 24
+
 func evalIntegerInfixExpr(
 	op string,
 	l object.Object,
 	r object.Object,
 ) object.Object {
-	lVal := l.(*object.Integer).Value
-	rVal := r.(*object.Integer).Value
+	lInt, lOk := l.(*object.Integer)
+	rInt, rOk := r.(*object.Integer)
+	if !lOk || !rOk {
+		return evalBigIntInfixExpr(op, l, r)
+	}
+	lVal, rVal := lInt.Value, rInt.Value
 	switch op {
 	case "+":
-		return &object.Integer{Value: lVal + rVal}
+		if sum := lVal + rVal; (sum > lVal) == (rVal > 0) {
+			return &object.Integer{Value: sum}
+		}
 	case "-":
-		return &object.Integer{Value: lVal - rVal}
+		if diff := lVal - rVal; (diff < lVal) == (rVal > 0) {
+			return &object.Integer{Value: diff}
+		}
 	case "*":
-		return &object.Integer{Value: lVal * rVal}
+		if lVal == 0 || rVal == 0 {
+			return &object.Integer{Value: 0}
+		}
+		prod := lVal * rVal
+		if prod/rVal == lVal && !(lVal == -1 && rVal == math.MinInt64) && !(rVal == -1 && lVal == math.MinInt64) {
+			return &object.Integer{Value: prod}
+		}
 	case "/":
-		return &object.Integer{Value: lVal / rVal}
+		if rVal == 0 {
+			return newError("division by zero")
+		}
+		if !(lVal == math.MinInt64 && rVal == -1) {
+			return &object.Integer{Value: lVal / rVal}
+		}
+	case "%":
+		if rVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: lVal % rVal}
 	case "<":
 		return toBoolObj(lVal < rVal)
 	case ">":
 		return toBoolObj(lVal > rVal)
+	case "<=":
+		return toBoolObj(lVal <= rVal)
+	case ">=":
+		return toBoolObj(lVal >= rVal)
 	case "==":
 		return toBoolObj(lVal == rVal)
 	case "!=":
 		return toBoolObj(lVal != rVal)
+	}
+	// the result overflows an int64, or the op is **
+	return evalBigIntInfixExpr(op, l, r)
+}
+
+func evalBigIntInfixExpr(
+	op string,
+	l object.Object,
+	r object.Object,
+) object.Object {
+	lVal, _ := object.ToBig(l)
+	rVal, _ := object.ToBig(r)
+	switch op {
+	case "+":
+		return object.NewInt(new(big.Int).Add(lVal, rVal))
+	case "-":
+		return object.NewInt(new(big.Int).Sub(lVal, rVal))
+	case "*":
+		return object.NewInt(new(big.Int).Mul(lVal, rVal))
+	case "/":
+		if rVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		// truncated like the division of int64s
+		return object.NewInt(new(big.Int).Quo(lVal, rVal))
+	case "%":
+		if rVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return object.NewInt(new(big.Int).Rem(lVal, rVal))
+	case "**":
+		return intPow(lVal, rVal)
+	case "<":
+		return toBoolObj(lVal.Cmp(rVal) < 0)
+	case ">":
+		return toBoolObj(lVal.Cmp(rVal) > 0)
+	case "<=":
+		return toBoolObj(lVal.Cmp(rVal) <= 0)
+	case ">=":
+		return toBoolObj(lVal.Cmp(rVal) >= 0)
+	case "==":
+		return toBoolObj(lVal.Cmp(rVal) == 0)
+	case "!=":
+		return toBoolObj(lVal.Cmp(rVal) != 0)
 	default:
 		return newError(
 			"unkown operator: %s %s %s",
@@ -444,10 +1044,25 @@ func evalIntegerInfixExpr(
 			op,
 			r.Type(),
 		)
-		// return NULL
 	}
 }
 
+// intPow is an integer, or a float for a negative exponent
+func intPow(base, exp *big.Int) object.Object {
+	if exp.Sign() < 0 {
+		b, _ := new(big.Float).SetInt(base).Float64()
+		e, _ := new(big.Float).SetInt(exp).Float64()
+		return &object.Float{Value: math.Pow(b, e)}
+	}
+	// 0, 1 and -1 stay small whatever the exponent
+	if base.CmpAbs(big.NewInt(1)) > 0 {
+		if !exp.IsInt64() || int64(base.BitLen()-1)*exp.Int64() > maxPowBits {
+			return newError("integer overflow: %s ** %s has more than %d bits", base, exp, maxPowBits)
+		}
+	}
+	return object.NewInt(new(big.Int).Exp(base, exp, nil))
+}
+
 func evalStringInfixExpr(
 	op string,
 	l object.Object,
@@ -458,6 +1073,19 @@ func evalStringInfixExpr(
 	switch op {
 	case "+":
 		return &object.String{Value: lVal + rVal}
+	// strings are compared byte by byte
+	case "<":
+		return toBoolObj(lVal < rVal)
+	case ">":
+		return toBoolObj(lVal > rVal)
+	case "<=":
+		return toBoolObj(lVal <= rVal)
+	case ">=":
+		return toBoolObj(lVal >= rVal)
+	case "==":
+		return toBoolObj(lVal == rVal)
+	case "!=":
+		return toBoolObj(lVal != rVal)
 	default:
 		return newError(
 			"unkown operator: %s %s %s",
@@ -469,7 +1097,78 @@ func evalStringInfixExpr(
 	}
 }
 
+func evalFloatInfixExpr(
+	op string,
+	l object.Object,
+	r object.Object,
+) object.Object {
+	lVal, _ := toFloat(l)
+	rVal, _ := toFloat(r)
+	switch op {
+	case "+":
+		return &object.Float{Value: lVal + rVal}
+	case "-":
+		return &object.Float{Value: lVal - rVal}
+	case "*":
+		return &object.Float{Value: lVal * rVal}
+	case "/":
+		return &object.Float{Value: lVal / rVal}
+	case "%":
+		return &object.Float{Value: math.Mod(lVal, rVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(lVal, rVal)}
+	case "<":
+		return toBoolObj(lVal < rVal)
+	case ">":
+		return toBoolObj(lVal > rVal)
+	case "<=":
+		return toBoolObj(lVal <= rVal)
+	case ">=":
+		return toBoolObj(lVal >= rVal)
+	case "==":
+		return toBoolObj(lVal == rVal)
+	case "!=":
+		return toBoolObj(lVal != rVal)
+	default:
+		return newError(
+			"unkown operator: %s %s %s",
+			l.Type(),
+			op,
+			r.Type(),
+		)
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	_, ok := toFloat(obj)
+	return ok
+}
+
+// toFloat is the value of an integer or a float as a float64
+func toFloat(obj object.Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value), true
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f, true
+	case *object.Float:
+		return obj.Value, true
+	}
+	return 0, false
+}
+
 func evalMinusOperatorExpr(r object.Object) object.Object {
+	switch r := r.(type) {
+	case *object.Float:
+		return &object.Float{Value: -r.Value}
+	case *object.BigInt:
+		return object.NewInt(new(big.Int).Neg(r.Value))
+	case *object.Integer:
+		if r.Value == math.MinInt64 {
+			return object.NewInt(new(big.Int).Neg(big.NewInt(r.Value)))
+		}
+	}
 	if r.Type() != object.INT_OBJ {
 		return newError("unkown operator: -%s", r.Type())
 		// return NULL
@@ -499,12 +1198,12 @@ func toBoolObj(input bool) *object.Boolean {
 	return FALSE
 }
 
-func evalProgram(stmts []ast.Stmt, env *object.Env) object.Object {
+func evalProgram(stmts []ast.Stmt, env *object.Env, ctx *object.Context) object.Object {
 	var res object.Object
 	// res will be the last evaluated stmt
 	for _, stmt := range stmts {
 
-		res = Eval(stmt, env)
+		res = Eval(stmt, env, ctx)
 		switch res := res.(type) {
 		case *object.ReturnValue:
 			return res.Value
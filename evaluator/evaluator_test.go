@@ -1,12 +1,20 @@
 package evaluator
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"bariq/lexer"
 	"bariq/object"
 	"bariq/parser"
+	"bariq/sched"
 )
 
 func testEval(input string) object.Object {
@@ -14,7 +22,7 @@ func testEval(input string) object.Object {
 	p := parser.New(l)
 	program := p.ParseProgram()
 	env := object.NewEnv()
-	return Eval(program, env)
+	return Eval(program, env, object.NewContext())
 }
 
 func TestEvalIntegerExpr(t *testing.T) {
@@ -203,6 +211,7 @@ func TestErrorHandling(t *testing.T) {
 		{"foobar", "ident not found: foobar"},
 		{`"hello" - "world";`, "unkown operator: STRING - STRING"},
 		{`{"name":"y"}[fn(x){x}];`, "unusable as hash key: FUNCTION"},
+		{"let add = fn(a, b) { a + b }; add(1)", "wrong number of args for add, got 1, want 2"},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -564,3 +573,754 @@ func testIterationObject(
 	}
 	return true
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "1:3"},
+		{"let a = 1;\n\nfoobar", "3:1"},
+		{"let f = fn(x) {\n  x + \"s\"\n};\nf(1)", "2:5"},
+		{"let a = 5;\n-true;", "2:1"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned, got %T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Pos.String() != tt.expected {
+			t.Errorf(
+				"wrong error position, expected %q, got %q",
+				tt.expected,
+				errObj.Pos.String(),
+			)
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `
+	let inner = fn(x) { x + true };
+	let outer = fn(y) { inner(y) };
+	let wrap = fn() { outer(1) };
+	wrap();
+	`
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned, got %T(%+v)", evaluated, evaluated)
+	}
+	expected := []struct {
+		name string
+		pos  string
+	}{
+		{"inner", "3:27"},
+		{"outer", "4:25"},
+		{"wrap", "5:6"},
+	}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong number of frames, expected %d, got %d: %+v",
+			len(expected), len(errObj.Stack), errObj.Stack)
+	}
+	for i, tt := range expected {
+		frame := errObj.Stack[i]
+		if frame.Name != tt.name {
+			t.Errorf("frame[%d] wrong name, expected %q, got %q", i, tt.name, frame.Name)
+		}
+		if frame.Pos.String() != tt.pos {
+			t.Errorf("frame[%d] wrong position, expected %q, got %q", i, tt.pos, frame.Pos)
+		}
+	}
+}
+
+func TestImportExpr(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib/math.bq": `let square = fn(x) { x * x }; let base = 10;`,
+		"lib/util.bq": `let m = import "math.bq"; let cube = fn(x) { x * m.square(x) };`,
+		"cycle_a.bq":  `let b = import "cycle_b.bq";`,
+		"cycle_b.bq":  `let a = import "cycle_a.bq";`,
+		"broken.bq":   `let = 1;`,
+		"slow.bq":     `let i = 0; while (i < 100000) { i += 1 }`,
+		"self.bq":     `let t = async fn() { import "self.bq" }(); let r = await(t);`,
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{fmt.Sprintf(`let m = import %q; m.square(3)`, path("lib/math.bq")), 9},
+		{fmt.Sprintf(`let m = import %q; m["base"]`, path("lib/math.bq")), 10},
+		{fmt.Sprintf(`let u = import %q; u.cube(2)`, path("lib/util.bq")), 8},
+		{
+			fmt.Sprintf(`let a = import %q; let b = import %q; a == b`,
+				path("lib/math.bq"), path("lib/math.bq")),
+			true,
+		},
+		{fmt.Sprintf(`let m = import %q; m.nope`, path("lib/math.bq")), "module math has no binding nope"},
+		{fmt.Sprintf(`import %q`, path("cycle_a.bq")), `import cycle: "cycle_a.bq" is imported while being loaded`},
+		{`import "does/not/exist.bq"`, `import "does/not/exist.bq": open does/not/exist.bq: no such file or directory`},
+		// the second task waits for the module the first one is loading
+		{
+			fmt.Sprintf(`let load = async fn() { import %q }; let ms = awaitAll([load(), load()]); ms[0] == ms[1]`,
+				path("slow.bq")),
+			true,
+		},
+		{fmt.Sprintf(`import %q`, path("self.bq")), `import cycle: "self.bq" is imported while being loaded`},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error, got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("error message not matched, got %s, want %s", errObj.Message, expected)
+			}
+		}
+	}
+}
+
+func TestImportCachePerContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "m.bq")
+	input := fmt.Sprintf(`let m = import %q; m.x`, path)
+	for _, want := range []int64{1, 2} {
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("let x = %d;", want)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		ctx := object.NewContext()
+		for i := 0; i < 2; i++ {
+			testIntegerObject(t, Eval(parser.New(lexer.New(input)).ParseProgram(), object.NewEnv(), ctx), want)
+		}
+	}
+}
+
+func TestGeneratorSuspension(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected *object.Iteration
+	}{
+		{
+			// yields inside nested blocks resume where they left off
+			`let s = fn gen (n) {
+				if (n > 0) {
+					yield 1;
+					if (true) { yield 2; yield 3; }
+					yield 4;
+				}
+			};
+			let g = s(1);
+			next(g); next(g); next(g);
+			`,
+			&object.Iteration{Val: &object.Integer{Value: 3}},
+		},
+		{
+			// the statement after an if is not run twice
+			`let s = fn gen () {
+				let a = 10;
+				if (true) { yield a; }
+				yield a + 1;
+			};
+			let g = s();
+			next(g); next(g);
+			`,
+			&object.Iteration{Val: &object.Integer{Value: 11}},
+		},
+		{
+			// a function defined in the body yields from the generator
+			`let s = fn gen () {
+				let twice = fn(x) { yield x; yield x; };
+				twice(5);
+				yield 6;
+			};
+			let g = s();
+			next(g); next(g); next(g);
+			`,
+			&object.Iteration{Val: &object.Integer{Value: 6}},
+		},
+		{
+			// the value the body finished with is given when done
+			`let s = fn gen () { yield 1; return 9; };
+			let g = s();
+			next(g); next(g); next(g);
+			`,
+			&object.Iteration{Val: &object.Integer{Value: 9}, Done: true},
+		},
+		{
+			// each generator keeps its own state
+			`let s = fn gen (x) { yield x; yield x * 10; };
+			let a = s(1);
+			let b = s(2);
+			next(a); next(b); next(a);
+			`,
+			&object.Iteration{Val: &object.Integer{Value: 10}},
+		},
+	}
+	for _, tt := range tests {
+		testIterationObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestYieldOutsideGenerator(t *testing.T) {
+	tests := []string{
+		"yield 1",
+		"let f = fn() { yield 1 }; f()",
+	}
+	for _, input := range tests {
+		evaluated := testEval(input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned, got %T(%+v)", input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != "yield outside of a generator" {
+			t.Errorf("%q: wrong error message, got %q", input, errObj.Message)
+		}
+	}
+}
+
+func TestGeneratorClose(t *testing.T) {
+	defs := `let count = fn gen () { let i = 0; while (true) { yield i; i += 1 } };`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let g = count(); for (x in g) { if (x == 2) { break } }; next(g)`, "{value: null, done: true}"},
+		{`let n = 0; let g = fn gen () { yield 1; n += 1; yield 2 }; let it = g(); for (x in it) { break }; [n, next(it)]`, "[0, {value: null, done: true}]"},
+		{`let first = fn(g) { for (x in g) { return x } }; let g = count(); [first(g), next(g)]`, "[0, {value: null, done: true}]"},
+		{`let g = count(); for (x in g) { x + true }; next(g)`, "ERROR: 1:105: type mismatch: INTEGER + BOOLEAN"},
+		{`let g = count(); next(g); for (x in g) { break }; next(g)`, "{value: null, done: true}"},
+		{`let g = async fn gen () { yield 1; yield 2 }; let it = g(); for await (x in it) { break }; await(next(it))`, "{value: null, done: true}"},
+	}
+	for _, tt := range tests {
+		if got := testEval(defs + tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+
+	// the bodies of the generators left by a break end
+	before := runtime.NumGoroutine()
+	for i := 0; i < 200; i++ {
+		testEval(defs + `for (x in count()) { break }`)
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before+10; {
+		if time.Now().After(deadline) {
+			t.Fatalf("generator goroutines leaked, %d before, %d after", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var out bytes.Buffer
+	ctx := object.NewContext()
+	ctx.Tracer = NewWriterTracer(&out, TraceGen)
+	program := parser.New(lexer.New(`let s = fn gen () { yield 1 }; let g = s(); next(g); next(g); len("")`)).ParseProgram()
+	Eval(program, object.NewEnv(), ctx)
+	expected := `[gen] new generator s
+[gen] resume s
+[gen] yield 1 at 1:21
+[gen] resume s
+[gen] s done: 1
+`
+	if out.String() != expected {
+		t.Errorf("wrong trace.\nwant\n%s\ngot\n%s", expected, out.String())
+	}
+
+	// the tracer belongs to the context, async tasks use it too
+	out.Reset()
+	ctx.Tracer = NewWriterTracer(&out, TraceAsync)
+	Eval(parser.New(lexer.New(`let f = async fn() { 1 }; await(f())`)).ParseProgram(), object.NewEnv(), ctx)
+	// another context doesn't trace
+	testEval(`let f = async fn() { 1 }; await(f())`)
+	if strings.Count(out.String(), "[async] f done: 1") != 1 {
+		t.Errorf("wrong trace, got\n%s", out.String())
+	}
+}
+
+// inspected counts how many times it is inspected
+type inspected struct{ n *int }
+
+func (i inspected) Type() object.ObjectType { return "INSPECTED" }
+func (i inspected) Inspect() string         { *i.n++; return "inspected" }
+
+func TestTracerDisabled(t *testing.T) {
+	n := 0
+	ctx := object.NewContext()
+	ctx.Builtins = Builtins()
+	ctx.Builtins["value"] = &object.Builtin{Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+		return inspected{&n}
+	}}
+	// the values of the events not traced aren't inspected
+	ctx.Tracer = NewWriterTracer(io.Discard, TraceGen)
+	program := parser.New(lexer.New(`let f = fn(x) { x }; f(value()); await(async fn(x) { x }(value()))`)).ParseProgram()
+	Eval(program, object.NewEnv(), ctx)
+	if n != 0 {
+		t.Errorf("values inspected %d times", n)
+	}
+}
+
+func TestParseTraceKinds(t *testing.T) {
+	kinds, err := ParseTraceKinds("eval, async")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(kinds) != 2 || kinds[0] != TraceEval || kinds[1] != TraceAsync {
+		t.Errorf("wrong kinds, got %v", kinds)
+	}
+	if _, err := ParseTraceKinds("eval,nope"); err == nil {
+		t.Errorf("expected an error for an unknown kind")
+	}
+}
+
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.slept += d
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestContext(t *testing.T) {
+	var stdout bytes.Buffer
+	clock := &fakeClock{now: time.UnixMilli(1000)}
+	ctx := &object.Context{
+		Stdout: &stdout,
+		Stderr: io.Discard,
+		Stdin:  strings.NewReader("first line\nsecond\n"),
+		Clock:  clock,
+	}
+	input := `
+	puts(input());
+	let start = now();
+	sleep(2);
+	puts(now() - start, input(), input());
+	`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	Eval(program, object.NewEnv(), ctx)
+	expected := "first line\n2000\nsecond\nnull\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong output, want %q, got %q", expected, stdout.String())
+	}
+	if clock.slept != 2*time.Second {
+		t.Errorf("sleep didn't use the clock, slept %s", clock.slept)
+	}
+}
+
+func TestTaskCancellation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let s = async fn() { sleep(100); 1 }; let task = s(); cancel(task); await(task)`, "ERROR: 1:69: await: task cancelled"},
+		{`await(withTimeout(10, async fn() { sleep(100); 1 }))`, "ERROR: 1:1: await: task timed out"},
+		{`await(withTimeout(1000, async fn(x) { x * 2 }, 4))`, "8"},
+		{`let task = withTimeout(1000, async fn() { 1 }); let v = await(task); cancel(task); v`, "1"},
+		{`withTimeout(10, fn() { 1 })`, "ERROR: 1:12: second argument to `withTimeout` must be an async function, got fn() {\n1\n}"},
+		{`cancel(1)`, "ERROR: 1:7: argument to `cancel` not supported, got INTEGER"},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		evaluated := testEval(tt.input)
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("%s: the task wasn't stopped", tt.input)
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestTaskCombinators(t *testing.T) {
+	defs := `
+	let val = async fn(x) { x };
+	let fail = async fn() { 1 + true };
+	let slow = async fn() { sleep(100); 0 };
+	`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`awaitAll([val(1), val(2), 3])`, "[1, 2, 3]"},
+		{`awaitAll([])`, "[]"},
+		{`awaitAll([val(1), fail()])`, "ERROR: 3:28: type mismatch: INTEGER + BOOLEAN"},
+		{`awaitAny([fail(), val(2)])`, "2"},
+		{`awaitAny([fail(), fail()])`, "ERROR: 5:10: awaitAny: all tasks failed, last: type mismatch: INTEGER + BOOLEAN"},
+		{`race([slow(), val(4)])`, "4"},
+		{`let s = slow(); race([s, val(4)]); await(s)`, "ERROR: 5:37: await: task cancelled"},
+		{`let v = val(5); race([v]); await(v)`, "5"},
+		{`race([])`, "ERROR: 5:6: race: no tasks"},
+		{`let r = allSettled([val(1), fail()]); [r[0]["status"], r[0]["value"], r[1]["status"], r[1]["error"]]`,
+			"[fulfilled, 1, rejected, type mismatch: INTEGER + BOOLEAN]"},
+		{`awaitAll(1)`, "ERROR: 5:10: argument to `awaitAll` must be ARRAY, got INTEGER"},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		evaluated := testEval(defs + tt.input)
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("%s: slow tasks weren't cancelled", tt.input)
+		}
+		if got := evaluated.Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestChannels(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let c = chan(2); send(c, 1); send(c, 2); [recv(c), recv(c)]`, "[1, 2]"},
+		{`let c = chan(); let p = async fn() { send(c, "hi") }; p(); recv(c)`, "hi"},
+		{`let c = chan(1); send(c, 1); close(c); [recv(c), recv(c)]`, "[1, null]"},
+		{`let c = chan(); close(c); send(c, 1)`, "ERROR: 1:31: send on closed channel"},
+		{`let c = chan(); close(c); close(c)`, "ERROR: 1:32: close of closed channel"},
+		{`chan(-1)`, "ERROR: 1:5: argument to `chan` must be a positive INTEGER, got -1"},
+		{`chan(100000000000000)`, "ERROR: 1:5: argument to `chan` must be at most 1048576, got 100000000000000"},
+		{`recv(1)`, "ERROR: 1:5: argument to `recv` must be CHANNEL, got INTEGER"},
+		{`let c = chan(1); send(c, 5); select { case v, ok = recv(c) { [v, ok] } default { 0 } }`, "[5, true]"},
+		{`let c = chan(); select { case recv(c) { 1 } default { 2 } }`, "2"},
+		{`let c = chan(); select { case recv(c) { 1 } case timeout(10) { 2 } }`, "2"},
+		{`let c = chan(1); select { case send(c, 3) { recv(c) } }`, "3"},
+		{`let c = chan(); close(c); select { case v, ok = recv(c) { [v, ok] } }`, "[null, false]"},
+		{`let c = chan(); close(c); select { case send(c, 1) { 1 } }`, "ERROR: 1:46: send on closed channel"},
+		{`let a = chan(); let b = chan();
+		let p = async fn() { send(b, "b") }; p();
+		select { case x = recv(a) { x } case y = recv(b) { y } }`, "b"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if got := evaluated.Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`concurrency()`, "4"},
+		{`[concurrency(2), concurrency()]`, "[4, 2]"},
+		{`concurrency(0)`, "ERROR: 1:12: argument to `concurrency` must be an INTEGER above 0, got 0"},
+		// nested awaits don't wait for a free worker
+		{`concurrency(1);
+		let inner = async fn(x) { x * 2 };
+		let outer = async fn(x) { await(inner(x)) + 1 };
+		awaitAll([outer(1), outer(2), outer(3)])`, "[3, 5, 7]"},
+	}
+	for _, tt := range tests {
+		ctx := object.NewContext()
+		ctx.Sched = sched.New(4)
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, object.NewEnv(), ctx)
+		if got := evaluated.Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+
+	// the tasks of a program wait for a worker
+	ctx := object.NewContext()
+	ctx.Sched = sched.New(3)
+	input := `
+	let c = chan();
+	let wait = async fn() { recv(c) };
+	let tasks = [wait(), wait(), wait(), wait(), wait()];
+	tasks
+	`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	tasks := Eval(program, object.NewEnv(), ctx).(*object.Array)
+	deadline := time.Now().Add(5 * time.Second)
+	for ctx.Sched.Metrics().Running != 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if m := ctx.Sched.Metrics(); m.Running != 3 || m.Queued != 2 {
+		t.Errorf("want 3 running and 2 queued tasks, got %+v", m)
+	}
+	for _, el := range tasks.Elements {
+		el.(*object.Task).Spawned.Cancel()
+	}
+}
+
+func TestAsyncErrors(t *testing.T) {
+	input := `let check = fn(x) { x + true };
+let work = async fn(x) { check(x) };
+let task = work(1);
+let run = fn() { await(task) };
+run();`
+	expected := `Traceback (most recent call last):
+  5:4: call to run
+  4:18: call to await
+  2:31: call to check
+ERROR: 1:23: type mismatch: INTEGER + BOOLEAN`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	env := object.NewEnv()
+	ctx := object.NewContext()
+	for i := 0; i < 2; i++ {
+		// awaiting again re-raises the same error
+		errObj, ok := Eval(program, env, ctx).(*object.Error)
+		if !ok {
+			t.Fatalf("expected an error")
+		}
+		if got := errObj.Traceback(); got != expected {
+			t.Errorf("wrong traceback, want\n%s\ngot\n%s", expected, got)
+		}
+	}
+
+	res := testEval(`let fail = async fn() { 1 + true }; let t = fail(); [await(t), 1]`)
+	if res.Inspect() != "ERROR: 1:27: type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("the error of a task should stop the awaiting code, got %s", res.Inspect())
+	}
+}
+
+func TestAsyncGenerators(t *testing.T) {
+	defs := `
+	let fetch = async fn(x) { x * 10 };
+	let numbers = async fn gen (n) {
+		yield await(fetch(1));
+		yield await(fetch(n));
+		"end"
+	};
+	`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let g = numbers(2); let a = next(g); let b = next(g); [await(b), await(a), await(next(g))]`,
+			"[{value: 20, done: false}, {value: 10, done: false}, {value: end, done: true}]"},
+		{`let g = numbers(2); next(g)`, "<task>"},
+		{`let sum = fn(g) { let total = [] ; for await (x in g) { let total = push(total, x) }; total }; sum(numbers(3))`, "[10, 30]"},
+		{`let find = fn() { for await (x in numbers(4)) { if (x > 10) { return x } } }; find()`, "40"},
+		{`let g = fn gen () { yield 1; yield 2 }; let f = fn() { for await (x in g()) { if (x == 2) { return x } } }; f()`, "2"},
+		{`for await (x in numbers(1)) { x + true }`, "ERROR: 8:34: type mismatch: INTEGER + BOOLEAN"},
+		{`let bad = async fn gen () { yield 1; 1 + true }; for await (x in bad()) { x }`, "ERROR: 8:41: type mismatch: INTEGER + BOOLEAN"},
+		{`let xs = []; for await (x in [fetch(1), 2]) { let xs = push(xs, x) }; xs`, "[10, 2]"},
+		{`for (x in numbers(1)) {}`, "ERROR: 8:19: async generators are iterated with for await"},
+	}
+	for _, tt := range tests {
+		if got := testEval(defs + tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let i = 0; while (i < 5) { let i = i + 1 }; i`, "5"},
+		{`let i = 0; while (true) { let i = i + 1; if (i == 3) { break } }; i`, "3"},
+		{`while (false) { 1 }`, "null"},
+		{`let out = []; for (x in [1, 2, 3, 4]) { if (x == 2) { continue }; let out = push(out, x) }; out`, "[1, 3, 4]"},
+		{`let out = []; for (i, x in ["a", "b"]) { let out = push(out, i); let out = push(out, x) }; out`, "[0, a, 1, b]"},
+		{`let out = []; for (c in "héy") { let out = push(out, c) }; out`, "[h, é, y]"},
+		{`let out = []; for (k in {"b": 2, "a": 1}) { let out = push(out, k) }; out`, "[a, b]"},
+		{`let out = []; for (k, v in {3: "c", 1: "a", 2: "b"}) { let out = push(out, [k, v]) }; out`, "[[1, a], [2, b], [3, c]]"},
+		{`let g = fn gen () { yield 1; yield 2; yield 3 }; let out = []; for (x in g()) { let out = push(out, x) }; out`, "[1, 2, 3]"},
+		{`let find = fn(xs) { for (x in xs) { if (x > 1) { return x } }; 0 }; [find([1, 5, 7]), find([])]`, "[5, 0]"},
+		{`let out = []; for (x in [1, 2]) { for (y in [1, 2]) { if (y == 2) { break }; let out = push(out, [x, y]) } }; out`, "[[1, 1], [2, 1]]"},
+		{`let c = chan(1); let n = 0; while (n < 3) { send(c, n); select { case v = recv(c) { if (v == 1) { break } } }; let n = n + 1 }; n`, "1"},
+		// break, continue and return leave the expressions holding them
+		{`let i = 0; while (true) { let x = if (i == 3) { break }; i = i + 1 }; i`, "3"},
+		{`let out = []; for (i in [1, 2, 3]) { out = push(out, if (i == 2) { continue } else { i }) }; out`, "[1, 3]"},
+		{`let n = 0; for (i in [1, 2, 3]) { n = n + (if (i == 2) { break } else { i }) }; n`, "1"},
+		{`let f = fn() { let x = [if (true) { return 1 }]; 2 }; f()`, "1"},
+		{`for (x in 5) {}`, "ERROR: 1:11: cannot iterate over INTEGER"},
+		{`while (1 + true) {}`, "ERROR: 1:10: type mismatch: INTEGER + BOOLEAN"},
+		{`for (x in [1]) { x + true }`, "ERROR: 1:20: type mismatch: INTEGER + BOOLEAN"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x = 1; x = 2; x`, "2"},
+		{`let x = 1; let y = 2; x = y = 7; [x, y]`, "[7, 7]"},
+		{`let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x`, "6"},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{`let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()`, "3"},
+		{`let i = 0; while (i < 5) { i += 1 }; i`, "5"},
+		// concurrent updates of a name aren't lost
+		{`let n = 0; let inc = async fn() { n += 1 }; let ts = []; let i = 0; while (i < 50) { ts = push(ts, inc()); i += 1 }; awaitAll(ts); n`, "50"},
+		{`let a = [1, [2, 3]]; a[1][0] = 9; a[0] *= 10; a`, "[10, [9, 3]]"},
+		// index assignment rebinds the name to a copy
+		{`let a = [1, 2]; let b = a; a[0] = 5; [a, b]`, "[[5, 2], [1, 2]]"},
+		{`let h = {"x": 1}; h["y"] = 2; h["x"] -= 5; [h["x"], h["y"]]`, "[-4, 2]"},
+		{`let f = fn() { let x = 1; let g = fn() { x = 2 }; g(); x }; f()`, "2"},
+		{`x = 1`, "ERROR: 1:1: cannot assign to undeclared x"},
+		{`let x = 1; x += true`, "ERROR: 1:14: type mismatch: INTEGER + BOOLEAN"},
+		{`let a = [1]; a[3] = 1`, "ERROR: 1:15: index out of range: 3, len 1"},
+		{`let s = "ab"; s[0] = "c"`, "ERROR: 1:16: index assignment not supported: STRING"},
+		{`let h = {}; h[[1]] = 1`, "ERROR: 1:14: unusable as hash key: ARRAY"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestConstAndFrozen(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`const x = 1; x + 1`, "2"},
+		{`const x = 1; x = 2`, "ERROR: 1:16: cannot assign to const x"},
+		{`const x = 1; let f = fn() { x += 1 }; f()`, "ERROR: 1:31: cannot assign to const x"},
+		{`const x = 1; let x = 2`, "ERROR: 1:14: cannot redeclare const x"},
+		{`let x = 1; const x = 2; x`, "2"},
+		// a function has its own scope
+		{`const x = 1; let f = fn() { let x = 2; x = 3; x }; [f(), x]`, "[3, 1]"},
+		// a loop body runs the same declaration again
+		{`let i = 0; while (i < 3) { const y = i; i += 1 }; y`, "2"},
+		{`const x = 1; for (x in [5, 6]) {}; x`, "ERROR: 1:19: cannot assign to const x"},
+		{`const i = 0; for (i, x in [5, 6]) {}`, "ERROR: 1:19: cannot assign to const i"},
+		{`const v = 1; let c = chan(1); send(c, 5); select { case v = recv(c) { v } }; v`, "ERROR: 1:57: cannot assign to const v"},
+		{`const ok = 1; let c = chan(1); send(c, 5); select { case v, ok = recv(c) { v } }`, "ERROR: 1:61: cannot assign to const ok"},
+		{`let f = fn() { for (x in [5, 6]) {}; x }; const x = 1; f()`, "6"},
+		{`let a = freeze([1, [2]]); [isFrozen(a), isFrozen(a[1]), isFrozen(push(a, 3)), isFrozen([1])]`, "[true, true, true, false]"},
+		{`let a = freeze([1]); a[0] = 2`, "ERROR: 1:23: cannot assign to an element of a frozen ARRAY"},
+		{`let h = freeze({"a": [1]}); h["a"][0] = 2`, "ERROR: 1:30: cannot assign to an element of a frozen HASH"},
+		{`let h = freeze({"a": 1}); h["b"] = 2`, "ERROR: 1:28: cannot assign to an element of a frozen HASH"},
+		// freezing copies, other references can still change the original
+		{`let a = [1]; let b = a; let c = freeze(a); b[0] = 2; [a, b, c]`, "[[1], [2], [1]]"},
+		{`let a = [1]; freeze(a); a[0] = 2; a`, "[2]"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFloats(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1.5`, "1.5"},
+		{`2.0`, "2.0"},
+		{`1.5e3 + 2e-1`, "1500.2"},
+		{`-0.5 * 4`, "-2.0"},
+		{`(1 + 2 + 4) / 2.0`, "3.5"},
+		{`7 / 2`, "3"},
+		{`1 / 0.0`, "+Inf"},
+		{`[1 == 1.0, 1 < 1.5, 2.5 > 3, 0.1 + 0.2 != 0.3]`, "[true, true, false, true]"},
+		{`let h = {1: "a", 2.5: "b"}; [h[1.0], h[2.5]]`, "[a, b]"},
+		{`let out = []; for (k in {2: 0, 1.5: 0, 1: 0}) { out = push(out, k) }; out`, "[1, 1.5, 2]"},
+		{`let x = 1; x += 0.5; x`, "1.5"},
+		{`[int(2.9), int(-2.9), int("42"), float(3), float("2.5"), int(7)]`, "[2, -2, 42, 3.0, 2.5, 7]"},
+		{`int("x")`, `ERROR: 1:4: cannot convert "x" to INTEGER`},
+		{`int(1 / 0.0)`, "ERROR: 1:4: cannot convert +Inf to INTEGER"},
+		{`float([1])`, "ERROR: 1:6: argument to `float` not supported, got ARRAY"},
+		{`1.5 + "a"`, "ERROR: 1:5: type mismatch: FLOAT + STRING"},
+		{`[1][1.0]`, "ERROR: 1:4: index operator not supported: ARRAY "},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`9223372036854775807 + 1`, "9223372036854775808"},
+		{`-9223372036854775807 - 2`, "-9223372036854775809"},
+		{`3037000500 * 3037000500`, "9223372037000250000"},
+		{`-(-9223372036854775807 - 1)`, "9223372036854775808"},
+		{`(-9223372036854775807 - 1) / -1`, "9223372036854775808"},
+		{`99999999999999999999 - 99999999999999999998`, "1"},
+		{`(9223372036854775807 + 1) * 2 / 4`, "4611686018427387904"},
+		{`2 ** 100`, "1267650600228229401496703205376"},
+		{`2 ** 3 ** 2`, "512"},
+		{`-2 ** 2`, "-4"},
+		{`2 ** -1`, "0.5"},
+		{`2.0 ** 0.5 > 1.41`, "true"},
+		{`[7 % 3, -7 % 3, 7 % -3, 7.5 % 2]`, "[1, -1, 1, 1.5]"},
+		{`(2 ** 70) % 1000`, "424"},
+		{`[2 ** 64 > 2 ** 63, 2 ** 64 == 2 ** 64, 2 ** 64 < 1]`, "[true, true, false]"},
+		{`let h = {2 ** 64: "big"}; h[18446744073709551616]`, "big"},
+		{`let x = 9223372036854775807; x += 1; x`, "9223372036854775808"},
+		{`int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+		{`float(2 ** 64)`, "18446744073709552000.0"},
+		{`[1, 2][2 ** 64]`, "null"},
+		{`1 / 0`, "ERROR: 1:3: division by zero"},
+		{`5 % 0`, "ERROR: 1:3: division by zero"},
+		{`(2 ** 64) / 0`, "ERROR: 1:11: division by zero"},
+		{`let f = fn(x) { 10 / x }; f(0)`, "ERROR: 1:20: division by zero"},
+		{`2 ** 100000000`, "ERROR: 1:3: integer overflow: 2 ** 100000000 has more than 16777216 bits"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[1 <= 2, 2 <= 2, 3 <= 2, 1 >= 2, 2 >= 2, 3 >= 2]`, "[true, true, false, false, true, true]"},
+		{`[1.5 <= 1, 2 >= 1.5, 2 ** 64 >= 2 ** 63, -(2 ** 64) <= 1]`, "[false, true, true, true]"},
+		{`["a" < "b", "b" > "a", "ab" <= "ab", "a" >= "ab", "" < "a"]`, "[true, true, true, false, true]"},
+		{`["a" == "a", "a" != "a", "a" == "b"]`, "[true, false, false]"},
+		{`[true && false, true || false, false || false, true && true]`, "[false, true, false, true]"},
+		{`[1 && 2, 0 && 2, [][0] && 2, false || "x", 1 || 2]`, "[2, 2, null, x, 1]"},
+		{`let name = [][0]; name || "anon"`, "anon"},
+		{`false && 1 / 0`, "false"},
+		{`true || undefined`, "true"},
+		{`let n = 0; let inc = fn() { n += 1; true }; false && inc(); true || inc(); true && inc(); n`, "1"},
+		{`let x = 5; x > 0 && x < 10 || x == 100`, "true"},
+		{`true && 1 / 0`, "ERROR: 1:11: division by zero"},
+		{`false || undefined`, "ERROR: 1:10: ident not found: undefined"},
+		{`"a" <= 1`, "ERROR: 1:5: type mismatch: STRING <= INTEGER"},
+		{`true >= false`, "ERROR: 1:6: unkown operator: BOOLEAN >= BOOLEAN"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
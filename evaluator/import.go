@@ -0,0 +1,78 @@
+package evaluator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bariq/ast"
+	"bariq/lexer"
+	"bariq/object"
+	"bariq/parser"
+)
+
+func evalImportExpr(node *ast.ImportExpr, ctx *object.Context) object.Object {
+	path := resolveImportPath(node)
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return newError("import %q: %s", node.Path, err)
+	}
+	if !ctx.EnterModule(key) {
+		return newError("import cycle: %q is imported while being loaded", node.Path)
+	}
+	defer ctx.LeaveModule()
+	return ctx.Modules().Load(key, ctx, func() object.Object {
+		return loadModule(path, ctx)
+	})
+}
+
+// resolveImportPath makes relative imports relative
+// to the directory of the importing file
+func resolveImportPath(node *ast.ImportExpr) string {
+	if filepath.IsAbs(node.Path) {
+		return filepath.Clean(node.Path)
+	}
+	from := node.Pos().File
+	if from == "" || strings.HasPrefix(from, "<") {
+		return filepath.Clean(node.Path)
+	}
+	return filepath.Join(filepath.Dir(from), node.Path)
+}
+
+func loadModule(path string, ctx *object.Context) object.Object {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return newError("import %q: %s", path, err)
+	}
+	l := lexer.NewFile(path, string(src))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return newError("import %q: %s", path, strings.Join(p.Errors(), "; "))
+	}
+	macroEnv := object.NewEnv()
+	DefineMacros(program, macroEnv)
+	expanded, errObj := ExpandMacros(program, macroEnv, ctx)
+	if errObj != nil {
+		return errObj
+	}
+	env := object.NewEnv()
+	if res := Eval(expanded, env, ctx); isError(res) {
+		return res
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &object.Module{Name: name, Path: path, Env: env}
+}
+
+func evalModuleIndexExpr(module, index object.Object) object.Object {
+	mod := module.(*object.Module)
+	name, ok := index.(*object.String)
+	if !ok {
+		return newError("unusable as module member: %s", index.Type())
+	}
+	val, ok := mod.Env.Get(name.Value)
+	if !ok {
+		return newError("module %s has no binding %s", mod.Name, name.Value)
+	}
+	return val
+}
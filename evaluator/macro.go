@@ -0,0 +1,256 @@
+package evaluator
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"bariq/ast"
+	"bariq/object"
+	"bariq/token"
+)
+
+func quote(node ast.Node, env *object.Env, ctx *object.Context) object.Object {
+	// unquote calls are swapped with placeholders while renaming
+	// bindings, so the code they insert is left as it is
+	unquotes := map[string]*ast.CallExpr{}
+	node = ast.Modify(node, func(node ast.Node) ast.Node {
+		if !isUnquoteCall(node) {
+			return node
+		}
+		name := "@unquote" + strconv.Itoa(len(unquotes))
+		unquotes[name] = node.(*ast.CallExpr)
+		return &ast.Ident{Token: node.(*ast.CallExpr).Token, Value: name}
+	})
+	node = hygienic(node)
+
+	var err *object.Error
+	node = ast.Modify(node, func(node ast.Node) ast.Node {
+		ident, ok := node.(*ast.Ident)
+		if !ok {
+			return node
+		}
+		call, ok := unquotes[ident.Value]
+		if !ok {
+			return node
+		}
+		if len(call.Args) != 1 {
+			err = newError("wrong number of args for unquote, got %d, want 1", len(call.Args))
+			return node
+		}
+		unquoted := Eval(call.Args[0], env, ctx)
+		if isError(unquoted) {
+			err = unquoted.(*object.Error)
+			return node
+		}
+		res, ok := objectToASTNode(unquoted, call.Token)
+		if !ok {
+			err = newError("unquote can't convert %s back to code", unquoted.Type())
+			return node
+		}
+		return res
+	})
+	if err != nil {
+		return err
+	}
+	return &object.Quote{Node: node}
+}
+
+func isUnquoteCall(node ast.Node) bool {
+	call, ok := node.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := call.Function.(*ast.Ident)
+	return ok && ident.Value == "unquote"
+}
+
+// objectToASTNode turns the result of an unquote back into code,
+// tok is the unquote call token, used for the position of new nodes
+func objectToASTNode(obj object.Object, tok token.Token) (ast.Expr, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		t := token.Token{Type: token.INT, Literal: fmt.Sprintf("%d", obj.Value), Pos: tok.Pos}
+		return &ast.IntLiteral{Token: t, Value: obj.Value}, true
+	case *object.BigInt:
+		t := token.Token{Type: token.INT, Literal: obj.Value.String(), Pos: tok.Pos}
+		return &ast.IntLiteral{Token: t, Big: obj.Value}, true
+	case *object.Float:
+		t := token.Token{Type: token.FLOAT, Literal: obj.Inspect(), Pos: tok.Pos}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}, true
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value, Pos: tok.Pos}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}, true
+	case *object.Boolean:
+		t := token.Token{Type: token.FALSE, Literal: "false", Pos: tok.Pos}
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true", Pos: tok.Pos}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}, true
+	case *object.Array:
+		t := token.Token{Type: token.LBRACKET, Literal: "[", Pos: tok.Pos}
+		arr := &ast.ArrayLiteral{Token: t}
+		for _, el := range obj.Elements {
+			n, ok := objectToASTNode(el, tok)
+			if !ok {
+				return nil, false
+			}
+			arr.Elmnts = append(arr.Elmnts, n)
+		}
+		return arr, true
+	case *object.Quote:
+		expr, ok := obj.Node.(ast.Expr)
+		return expr, ok
+	default:
+		return nil, false
+	}
+}
+
+// DefineMacros moves the top level `let name = macro(...) {...}`
+// statements out of the program into env
+func DefineMacros(program *ast.Program, env *object.Env) {
+	stmts := program.Stmts[:0]
+	for _, stmt := range program.Stmts {
+		letStmt, ok := stmt.(*ast.LetStmt)
+		if !ok {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		lit, ok := letStmt.Value.(*ast.MacroLiteral)
+		if !ok {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		env.Set(letStmt.Name.Value, &object.Macro{
+			Parameters: lit.Parameters,
+			Body:       lit.Body,
+			Env:        env,
+		})
+	}
+	program.Stmts = stmts
+}
+
+// maxExpansionDepth bounds the expansions of macros expanding
+// to macro calls, ex: of a macro expanding to a call of itself
+const maxExpansionDepth = 100
+
+// ExpandMacros replaces every call of a macro defined in env with the code
+// it returns, the program passed in is not changed. Macro bodies are
+// evaluated with ctx.
+func ExpandMacros(program *ast.Program, env *object.Env, ctx *object.Context) (*ast.Program, *object.Error) {
+	expanded, err := expandMacros(program, env, ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	return expanded.(*ast.Program), nil
+}
+
+// expandMacros expands the macro calls of node, then those of the
+// code they expand to, depth is the number of expansions node is in
+func expandMacros(node ast.Node, env *object.Env, ctx *object.Context, depth int) (ast.Node, *object.Error) {
+	var err *object.Error
+	expanded := ast.Modify(node, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || err != nil {
+			return node
+		}
+		macro, ok := isMacroCall(call, env)
+		if !ok {
+			return node
+		}
+		if len(call.Args) != len(macro.Parameters) {
+			err = newError(
+				"wrong number of args for macro %s, got %d, want %d",
+				call.Function.String(),
+				len(call.Args),
+				len(macro.Parameters),
+			)
+			err.Pos = call.Pos()
+			return node
+		}
+		if depth == maxExpansionDepth {
+			err = newError("macro %s expands to macro calls more than %d times", call.Function.String(), maxExpansionDepth)
+			err.Pos = call.Pos()
+			return node
+		}
+		evalEnv := object.NewEnclosedEnv(macro.Env)
+		for i, p := range macro.Parameters {
+			evalEnv.Set(p.Value, &object.Quote{Node: call.Args[i]})
+		}
+		evaluated := Eval(macro.Body, evalEnv, ctx)
+		if e, ok := evaluated.(*object.Error); ok {
+			err = e
+			return node
+		}
+		quoted, ok := unwrapReturnValue(evaluated).(*object.Quote)
+		if !ok {
+			err = newError("macro %s must return a quote, got %s", call.Function.String(), evaluated.Type())
+			err.Pos = call.Pos()
+			return node
+		}
+		res, e := expandMacros(quoted.Node, env, ctx, depth+1)
+		if e != nil {
+			err = e
+			return node
+		}
+		return res
+	})
+	return expanded, err
+}
+
+func isMacroCall(call *ast.CallExpr, env *object.Env) (*object.Macro, bool) {
+	ident, ok := call.Function.(*ast.Ident)
+	if !ok {
+		return nil, false
+	}
+	obj, ok := env.Get(ident.Value)
+	if !ok {
+		return nil, false
+	}
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+var gensym atomic.Int64
+
+// hygienic renames the names bound by `let`, function parameters,
+// loops and select cases in quoted code, so code a macro expands to
+// can't capture or shadow names used by its caller. a name is only
+// renamed in the scope of its binding, the function binding it or
+// the quoted code itself. the new names can't be written in source,
+// ex: x@3
+func hygienic(node ast.Node) ast.Node {
+	// the innermost functions first, the names they bind
+	// are no longer those of the enclosing ones then
+	node = ast.Modify(node, func(n ast.Node) ast.Node {
+		fn, ok := n.(*ast.FunctionLiteral)
+		if !ok {
+			return n
+		}
+		return rename(fn, append(ast.Binders(fn.Body), fn.Parameters...))
+	})
+	return rename(node, ast.Binders(node))
+}
+
+// rename gives new names to the names of bindings in node
+func rename(node ast.Node, bindings []*ast.Ident) ast.Node {
+	renames := map[string]string{}
+	for _, ident := range bindings {
+		if _, ok := renames[ident.Value]; !ok {
+			renames[ident.Value] = ident.Value + "@" + strconv.FormatInt(gensym.Add(1), 10)
+		}
+	}
+	if len(renames) == 0 {
+		return node
+	}
+	return ast.Modify(node, func(n ast.Node) ast.Node {
+		ident, ok := n.(*ast.Ident)
+		if !ok {
+			return n
+		}
+		if name, ok := renames[ident.Value]; ok {
+			return &ast.Ident{Token: ident.Token, Value: name}
+		}
+		return n
+	})
+}
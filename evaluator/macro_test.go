@@ -0,0 +1,318 @@
+package evaluator
+
+import (
+	"testing"
+
+	"bariq/ast"
+	"bariq/lexer"
+	"bariq/object"
+	"bariq/parser"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5 + 8)`},
+		{`quote(foobar)`, `foobar`},
+		{`quote(foobar + barfoo)`, `(foobar + barfoo)`},
+	}
+	for _, tt := range tests {
+		testQuoteObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote(4))`, `4`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8 + 8)`},
+		{`let foobar = 8; quote(foobar)`, `foobar`},
+		{`let foobar = 8; quote(unquote(foobar))`, `8`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote(quote(4 + 4)))`, `(4 + 4)`},
+		{`quote(unquote("hi"))`, `hi`},
+		{`quote(unquote([1, 2]))`, `[1, 2]`},
+		{`quote(unquote(1.5))`, `1.5`},
+		{`quote(unquote(3 / 2.0) * 2)`, `(1.5 * 2)`},
+		{`quote(unquote(2 ** 70))`, `1180591620717411303424`},
+		{`quote(unquote(-(2 ** 64)) + 1)`, `(-18446744073709551616 + 1)`},
+		{
+			`let q = quote(4 + 4); quote(unquote(4 + 4) + unquote(q))`,
+			`(8 + (4 + 4))`,
+		},
+	}
+	for _, tt := range tests {
+		testQuoteObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestUnquoteNumbers(t *testing.T) {
+	input := `
+	let twice = macro(e) { quote(unquote(e) * 2) };
+	let big = macro() { quote(unquote(2 ** 70) % 1000) };
+	let half = macro() { quote(unquote(1 / 2.0)) };
+	[twice(1.25), big(), half()]
+	`
+	program := testParseProgram(input)
+	macroEnv := object.NewEnv()
+	DefineMacros(program, macroEnv)
+	expanded, err := ExpandMacros(program, macroEnv, object.NewContext())
+	if err != nil {
+		t.Fatalf("expansion failed: %s", err.Inspect())
+	}
+	if got := Eval(expanded, object.NewEnv(), object.NewContext()).Inspect(); got != "[2.5, 424, 0.5]" {
+		t.Errorf("wrong result, want %q, got %q", "[2.5, 424, 0.5]", got)
+	}
+}
+
+func testQuoteObject(t *testing.T, obj object.Object, expected string) bool {
+	quote, ok := obj.(*object.Quote)
+	if !ok {
+		t.Errorf("object is not Quote. got %T (%+v)", obj, obj)
+		return false
+	}
+	if quote.Node == nil {
+		t.Errorf("quote.Node is nil")
+		return false
+	}
+	if quote.Node.String() != expected {
+		t.Errorf("not equal. got %q, want %q", quote.Node.String(), expected)
+		return false
+	}
+	return true
+}
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+	env := object.NewEnv()
+	program := testParseProgram(input)
+	DefineMacros(program, env)
+	if len(program.Stmts) != 2 {
+		t.Fatalf("wrong number of statements. got %d", len(program.Stmts))
+	}
+	if _, ok := env.Get("number"); ok {
+		t.Fatalf("number should not be defined")
+	}
+	if _, ok := env.Get("function"); ok {
+		t.Fatalf("function should not be defined")
+	}
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment.")
+	}
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got %T (%+v)", obj, obj)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("wrong number of macro parameters. got %d", len(macro.Parameters))
+	}
+	if macro.Body.String() != "(x + y)" {
+		t.Fatalf("body is not %q. got %q", "(x + y)", macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let infixExpression = macro() { quote(1 + 2); };
+			infixExpression();`,
+			`(1 + 2)`,
+		},
+		{
+			`let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };
+			reverse(2 + 2, 10 - 5);`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`let unless = macro(cond, cons, alt) {
+				quote(if (!(unquote(cond))) { unquote(cons); } else { unquote(alt); });
+			};
+			unless(10 > 5, puts("not greater"), puts("greater"));`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+		{
+			`let double = macro(x) { quote(unquote(x) * 2); };
+			let inc = macro(x) { quote(double(unquote(x)) + 1); };
+			inc(5);`,
+			`((5 * 2) + 1)`,
+		},
+		{
+			`let double = macro(x) { quote(unquote(x) * 2); };
+			let twice = macro(x) { quote(double(double(unquote(x)))); };
+			twice(1 + 2);`,
+			`(((1 + 2) * 2) * 2)`,
+		},
+	}
+	for _, tt := range tests {
+		expected := testParseProgram(tt.expected)
+		program := testParseProgram(tt.input)
+		env := object.NewEnv()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env, object.NewContext())
+		if err != nil {
+			t.Fatalf("expansion failed: %s", err.Inspect())
+		}
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want %q, got %q", expected.String(), expanded.String())
+		}
+	}
+}
+
+func TestExpandMacrosErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let m = macro(a) { quote(unquote(a)) }; m(1, 2);`,
+			"wrong number of args for macro m, got 2, want 1",
+		},
+		{
+			`let m = macro() { 1 }; m();`,
+			"macro m must return a quote, got INTEGER",
+		},
+		{
+			`let m = macro() { quote(m()) }; m();`,
+			"macro m expands to macro calls more than 100 times",
+		},
+	}
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		env := object.NewEnv()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env, object.NewContext())
+		if err == nil {
+			t.Errorf("expected an error for %q", tt.input)
+			continue
+		}
+		if err.Message != tt.expected {
+			t.Errorf("wrong error msg, expected %q, got %q", tt.expected, err.Message)
+		}
+	}
+}
+
+func TestMacroHygiene(t *testing.T) {
+	input := `
+	let addTo = macro(a, b) {
+		quote(fn(tmp) { let x = tmp; x + unquote(b) }(unquote(a)));
+	};
+	let twice = macro(e) { quote(unquote(e) + unquote(e)) };
+	let x = 100;
+	let tmp = 7;
+	addTo(1, x + tmp) + twice(1);
+	`
+	program := testParseProgram(input)
+	macroEnv := object.NewEnv()
+	DefineMacros(program, macroEnv)
+	expanded, err := ExpandMacros(program, macroEnv, object.NewContext())
+	if err != nil {
+		t.Fatalf("expansion failed: %s", err.Inspect())
+	}
+	testIntegerObject(t, Eval(expanded, object.NewEnv(), object.NewContext()), 110)
+}
+
+func TestMacroHygieneScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// x is only renamed in the function binding it
+		{
+			`let addX = macro(e) { quote(fn(x) { x + unquote(e) }(1) + x) };
+			let x = 10;
+			addX(100)`,
+			"111",
+		},
+		// the inner x is the parameter of the inner function
+		{
+			`let m = macro() { quote(fn(x) { [x, fn(x) { x * 2 }(x + 1)] }(1)) };
+			m()`,
+			"[1, 4]",
+		},
+		// closures refer to the bindings of the functions enclosing them
+		{
+			`let m = macro(e) { quote(fn(n) { let f = fn() { n + unquote(e) }; f() }(1)) };
+			let n = 5;
+			m(n)`,
+			"6",
+		},
+	}
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		macroEnv := object.NewEnv()
+		DefineMacros(program, macroEnv)
+		expanded, err := ExpandMacros(program, macroEnv, object.NewContext())
+		if err != nil {
+			t.Fatalf("expansion failed: %s", err.Inspect())
+		}
+		if got := Eval(expanded, object.NewEnv(), object.NewContext()).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestMacroHygieneLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let twice = macro(e) { quote(for (i in [1, 2]) { unquote(e) }) };
+			let i = 10;
+			let total = 0;
+			twice(total += i);
+			[i, total]`,
+			"[10, 20]",
+		},
+		{
+			`let each = macro(e) { quote(for (k, v in ["a"]) { unquote(e) }) };
+			let k = "k";
+			let v = "v";
+			let out = "";
+			each(out = k + v);
+			out`,
+			"kv",
+		},
+		{
+			`let recvPlus = macro(c, e) { quote(select { case v, ok = recv(unquote(c)) { unquote(e) + v } }) };
+			let v = 1;
+			let ok = 2;
+			let c = chan(1);
+			send(c, 5);
+			[recvPlus(c, v + ok), v, ok]`,
+			"[8, 1, 2]",
+		},
+	}
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		macroEnv := object.NewEnv()
+		DefineMacros(program, macroEnv)
+		expanded, err := ExpandMacros(program, macroEnv, object.NewContext())
+		if err != nil {
+			t.Fatalf("expansion failed: %s", err.Inspect())
+		}
+		if got := Eval(expanded, object.NewEnv(), object.NewContext()).Inspect(); got != tt.expected {
+			t.Errorf("%s: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func testParseProgram(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
@@ -0,0 +1,80 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"bariq/object"
+)
+
+// TraceKind is a category of trace events
+type TraceKind = object.TraceKind
+
+const (
+	// function calls and their results
+	TraceEval TraceKind = "eval"
+	// generators created, resumed and yielding
+	TraceGen TraceKind = "gen"
+	// async calls spawned and awaited
+	TraceAsync TraceKind = "async"
+)
+
+// Tracer receives the events of the evaluator, it is
+// set as the object.Context.Tracer of a program
+type Tracer = object.Tracer
+
+// traceOn tells if ctx traces the events of kind, the events
+// inspecting values check it first so they cost nothing otherwise
+func traceOn(ctx *object.Context, kind TraceKind) bool {
+	return ctx.Tracer != nil && ctx.Tracer.Enabled(kind)
+}
+
+// tracef sends an event to the tracer of ctx if it has one
+func tracef(ctx *object.Context, kind TraceKind, format string, a ...any) {
+	if !traceOn(ctx, kind) {
+		return
+	}
+	ctx.Tracer.Event(kind, fmt.Sprintf(format, a...))
+}
+
+// WriterTracer writes the events of some kinds to a writer,
+// one line each, ex: "[gen] yield 1"
+type WriterTracer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	kinds map[TraceKind]bool
+}
+
+func NewWriterTracer(w io.Writer, kinds ...TraceKind) *WriterTracer {
+	t := &WriterTracer{w: w, kinds: make(map[TraceKind]bool)}
+	for _, k := range kinds {
+		t.kinds[k] = true
+	}
+	return t
+}
+
+func (t *WriterTracer) Enabled(kind TraceKind) bool { return t.kinds[kind] }
+
+func (t *WriterTracer) Event(kind TraceKind, msg string) {
+	// async tasks trace from their own goroutines
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "[%s] %s\n", kind, msg)
+}
+
+// ParseTraceKinds parses a comma separated list of kinds, ex: "eval,gen"
+func ParseTraceKinds(s string) ([]TraceKind, error) {
+	kinds := []TraceKind{}
+	for _, name := range strings.Split(s, ",") {
+		switch k := TraceKind(strings.TrimSpace(name)); k {
+		case TraceEval, TraceGen, TraceAsync:
+			kinds = append(kinds, k)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown trace kind %q, want eval, gen or async", name)
+		}
+	}
+	return kinds, nil
+}
@@ -9,10 +9,20 @@ type Lexer struct {
 	position     int  // points to current char
 	readPosition int  // after cuurent char
 	ch           byte // char being examined
+
+	file   string
+	line   int // line of the current char
+	column int // column of the current char
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile is like New but records file as the
+// source name in the position of every token
+func NewFile(file, input string) *Lexer {
+	l := &Lexer{input: input, file: file, line: 1}
 	l.readChar()
 	return l
 }
@@ -20,6 +30,12 @@ func New(input string) *Lexer {
 // gives the next char and increment pos to
 // the next pos
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0 // 0 is the ASCII code for nul
 	} else {
@@ -37,9 +53,18 @@ func (l *Lexer) peakChar() byte {
 	}
 }
 
+// peekCharAt gives the char n positions after the current one
+func (l *Lexer) peekCharAt(n int) byte {
+	if i := l.position + n; i < len(l.input) {
+		return l.input[i]
+	}
+	return 0
+}
+
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 	l.skipWhitespace()
+	pos := l.pos()
 	switch l.ch {
 	case '"':
 		l.readChar()
@@ -68,11 +93,15 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.pair('=', token.SLASH_ASSIGN, token.SLASH)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.pair('=', token.GTE, token.GT)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.pair('=', token.LTE, token.LT)
+	case '&':
+		tok = l.pair('&', token.AND, token.ILLEGAL)
+	case '|':
+		tok = l.pair('|', token.OR, token.ILLEGAL)
 	case '!':
 		if l.peakChar() == '=' {
 			tok.Literal = "!="
@@ -82,15 +111,24 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.pair('=', token.MINUS_ASSIGN, token.MINUS)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.pair('=', token.PLUS_ASSIGN, token.PLUS)
 	case '*':
-		tok = newToken(token.ASTERIK, l.ch)
+		if l.peakChar() == '*' {
+			l.readChar()
+			tok = token.Token{Type: token.POWER, Literal: "**"}
+		} else {
+			tok = l.pair('=', token.ASTERIK_ASSIGN, token.ASTERIK)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -98,20 +136,26 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
 			// you already did an l.readChar()
 			return tok
 		}
 		if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos = pos
 			return tok
 		}
 		tok = newToken(token.ILLEGAL, l.ch)
 	}
+	tok.Pos = pos
 	l.readChar()
 	return tok
 }
 
+func (l *Lexer) pos() token.Position {
+	return token.Position{File: l.file, Line: l.line, Column: l.column}
+}
+
 func (l *Lexer) readString() string {
 	// fmt.Println("start string", string(l.ch))
 	position := l.position
@@ -130,12 +174,35 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an INT or a FLOAT like 1.5, 2e10 or 1.5e-3,
+// a dot not followed by a digit isn't part of the number
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	postition := l.position
+	tt := token.TokenType(token.INT)
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekCharAt(1)) {
+		tt = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekCharAt(1)
+		if isDigit(next) || (next == '+' || next == '-') && isDigit(l.peekCharAt(2)) {
+			tt = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+	return l.input[postition:l.position], tt
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[postition:l.position]
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -150,6 +217,17 @@ func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
 
+// pair is the two chars token tt if the next char is next,
+// or the token single of the current char
+func (l *Lexer) pair(next byte, tt, single token.TokenType) token.Token {
+	if l.peakChar() == next {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: tt, Literal: string(ch) + string(next)}
+	}
+	return newToken(single, l.ch)
+}
+
 func newToken(tt token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tt, Literal: string(ch)}
 }
@@ -23,6 +23,10 @@ func TestNextToken(t *testing.T) {
 	"foo bar"
 	[1,2];
 	{"foo":"bar"}
+	x += 1 -= 2 *= 3 /= 4
+	1.5 2e10 3.25E-2 4.x 5e
+	7 % 2 ** 3 *= 1
+	a <= b >= c && d || e
 	`
 	tests := []struct {
 		expectedType    token.TokenType
@@ -130,6 +134,39 @@ func TestNextToken(t *testing.T) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERIK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, "2e10"},
+		{token.FLOAT, "3.25E-2"},
+		{token.INT, "4"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
+		{token.INT, "5"},
+		{token.IDENT, "e"},
+		{token.INT, "7"},
+		{token.PERCENT, "%"},
+		{token.INT, "2"},
+		{token.POWER, "**"},
+		{token.INT, "3"},
+		{token.ASTERIK_ASSIGN, "*="},
+		{token.INT, "1"},
+		{token.IDENT, "a"},
+		{token.LTE, "<="},
+		{token.IDENT, "b"},
+		{token.GTE, ">="},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
 		{token.EOF, ""},
 	}
 	l := New(input)
@@ -155,3 +192,44 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  \"hi\" +\n\tfoo"
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"hi", 2, 3},
+		{"+", 2, 8},
+		{"foo", 3, 2},
+		{"", 3, 5},
+	}
+	l := NewFile("main.bq", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf(
+				"test[%d] - token literal wrong. exptected %q but got %q",
+				i,
+				tt.expectedLiteral, tok.Literal,
+			)
+		}
+		if tok.Pos.File != "main.bq" {
+			t.Fatalf("test[%d] - file wrong. got %q", i, tok.Pos.File)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf(
+				"test[%d] - position wrong. exptected %d:%d but got %d:%d",
+				i,
+				tt.expectedLine, tt.expectedColumn,
+				tok.Pos.Line, tok.Pos.Column,
+			)
+		}
+	}
+}
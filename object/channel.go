@@ -0,0 +1,95 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Channel passes values between async tasks, like a Go channel
+// except that sending on it once closed is an error and not a panic
+type Channel struct {
+	ch chan Object
+	// closed by Close, ch itself is never closed
+	closed chan struct{}
+	once   sync.Once
+}
+
+// MaxChannelCap is the largest capacity of a channel
+const MaxChannelCap = 1 << 20
+
+// NewChannel returns a channel buffering up to capacity values,
+// capacity must be between 0 and MaxChannelCap
+func NewChannel(capacity int) (*Channel, *Error) {
+	if capacity < 0 || capacity > MaxChannelCap {
+		return nil, &Error{Message: fmt.Sprintf("channel capacity %d out of range [0, %d]", capacity, MaxChannelCap)}
+	}
+	return &Channel{
+		ch:     make(chan Object, capacity),
+		closed: make(chan struct{}),
+	}, nil
+}
+
+func (c *Channel) Type() ObjectType { return CHANNEL_OBJ }
+func (c *Channel) Inspect() string {
+	return fmt.Sprintf("<chan %d/%d>", len(c.ch), cap(c.ch))
+}
+
+// Chan and Closed are the Go channels behind c, ex: to wait
+// on several channels at once
+func (c *Channel) Chan() chan Object       { return c.ch }
+func (c *Channel) Closed() <-chan struct{} { return c.closed }
+
+// Close closes c, values already buffered can still be received
+func (c *Channel) Close() *Error {
+	closed := false
+	c.once.Do(func() {
+		close(c.closed)
+		closed = true
+	})
+	if !closed {
+		return &Error{Message: "close of closed channel"}
+	}
+	return nil
+}
+
+// Send waits until val is sent, it fails once c is closed
+// or once ctx is done
+func (c *Channel) Send(ctx *Context, val Object) *Error {
+	select {
+	case <-c.closed:
+		return &Error{Message: "send on closed channel"}
+	default:
+	}
+	select {
+	case c.ch <- val:
+		return nil
+	case <-c.closed:
+		return &Error{Message: "send on closed channel"}
+	case <-ctx.Done():
+		return ctx.Stopped()
+	}
+}
+
+// Recv waits for a value, ok is false once c is closed
+// and all of its values are received
+func (c *Channel) Recv(ctx *Context) (val Object, ok bool, err *Error) {
+	select {
+	case val := <-c.ch:
+		return val, true, nil
+	case <-c.closed:
+		val, ok := c.TryRecv()
+		return val, ok, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Stopped()
+	}
+}
+
+// TryRecv receives a value if one is ready, NULL otherwise
+func (c *Channel) TryRecv() (Object, bool) {
+	select {
+	case val := <-c.ch:
+		return val, true
+	default:
+		return NULL, false
+	}
+}
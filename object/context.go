@@ -0,0 +1,307 @@
+package object
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bariq/sched"
+)
+
+// Context is what a running program uses from its host, it is
+// passed to Eval and to every builtin so hosts and tests can
+// capture the output or fake the time
+type Context struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+	Clock  Clock
+	// Builtins replaces the default builtins when it is set,
+	// ex: by a host registering its own functions
+	Builtins map[string]*Builtin
+	// Ctx stops the program once it is done, ex: at a deadline
+	Ctx    context.Context
+	Limits Limits
+	// Sched runs the async calls, sched.Default when nil
+	Sched *sched.Scheduler
+	// Tracer receives the events of the program, nil disables tracing
+	Tracer Tracer
+
+	once   sync.Once
+	shared *shared
+	// nested calls on the goroutine using this context
+	depth int
+	// absolute paths of the modules being loaded by
+	// the code using this context, innermost last
+	importing []string
+}
+
+// shared is the state of a context shared with its forks
+type shared struct {
+	stdinOnce sync.Once
+	stdin     *bufio.Reader
+	steps     atomic.Int64
+	alloc     atomic.Int64
+	modules   Modules
+}
+
+// Limits bound what a program can use, zero means no limit
+// except for MaxDepth which defaults to DefaultMaxDepth
+type Limits struct {
+	// evaluated nodes
+	MaxSteps int64
+	// nested function calls
+	MaxDepth int
+	// approximate bytes allocated by strings, arrays,
+	// hashes and call environments
+	MaxAlloc int64
+}
+
+// DefaultMaxDepth keeps deep recursions from overflowing the Go stack
+const DefaultMaxDepth = 10000
+
+// the errors wrapped by the *Error of exceeded limits, a program
+// stopped by Ctx wraps the error of Ctx (ex: context.DeadlineExceeded)
+var (
+	ErrStepLimit  = errors.New("step limit exceeded")
+	ErrDepthLimit = errors.New("call depth limit exceeded")
+	ErrAllocLimit = errors.New("allocation limit exceeded")
+)
+
+// TraceKind is a category of trace events
+type TraceKind string
+
+// Tracer receives the events of a running program, events
+// of a kind are only formatted when the tracer enables it
+type Tracer interface {
+	Enabled(kind TraceKind) bool
+	Event(kind TraceKind, msg string)
+}
+
+// Clock is the time as seen by a program
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the real time
+var SystemClock Clock = systemClock{}
+
+// NewContext returns a context using the standard streams of the process
+func NewContext() *Context {
+	return &Context{
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Stdin:  os.Stdin,
+		Clock:  SystemClock,
+	}
+}
+
+func (c *Context) state() *shared {
+	c.once.Do(func() {
+		if c.shared == nil {
+			c.shared = &shared{}
+		}
+	})
+	return c.shared
+}
+
+// Fork returns a context for code running on another goroutine,
+// ex: an async call, it shares the streams and the budget of c
+// but counts its own nested calls, starting from those of c since
+// an awaited task can run on the goroutine awaiting it
+func (c *Context) Fork() *Context {
+	return &Context{
+		Stdout:   c.Stdout,
+		Stderr:   c.Stderr,
+		Stdin:    c.Stdin,
+		Clock:    c.Clock,
+		Builtins: c.Builtins,
+		Ctx:      c.Ctx,
+		Limits:   c.Limits,
+		Sched:    c.Sched,
+		Tracer:   c.Tracer,
+		shared:   c.state(),
+		depth:    c.depth,
+		// a fork importing a module its parent is loading is a cycle
+		importing: c.importing[:len(c.importing):len(c.importing)],
+	}
+}
+
+// Scheduler is the scheduler running the async calls
+func (c *Context) Scheduler() *sched.Scheduler {
+	if c.Sched == nil {
+		return sched.Default
+	}
+	return c.Sched
+}
+
+// Modules is the cache of the modules imported
+// by the code using c and its forks
+func (c *Context) Modules() *Modules { return &c.state().modules }
+
+// EnterModule records that c loads the module at path, it fails
+// when c is already loading it, ex: a module importing itself. it
+// must be paired with LeaveModule when it doesn't fail
+func (c *Context) EnterModule(path string) bool {
+	for _, p := range c.importing {
+		if p == path {
+			return false
+		}
+	}
+	c.importing = append(c.importing, path)
+	return true
+}
+
+func (c *Context) LeaveModule() { c.importing = c.importing[:len(c.importing)-1] }
+
+// Reset clears the steps and allocations counted so far
+func (c *Context) Reset() {
+	c.state().steps.Store(0)
+	c.state().alloc.Store(0)
+}
+
+// ReadLine reads a line from Stdin without its line ending,
+// every read shares the same buffer
+func (c *Context) ReadLine() (string, error) {
+	s := c.state()
+	s.stdinOnce.Do(func() { s.stdin = bufio.NewReader(c.Stdin) })
+	line, err := s.stdin.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+	}
+	return line, err
+}
+
+// Done is closed when the program must stop, nil if it never does
+func (c *Context) Done() <-chan struct{} {
+	if c.Ctx == nil {
+		return nil
+	}
+	return c.Ctx.Done()
+}
+
+// Stopped returns the error to stop with once Ctx is done
+func (c *Context) Stopped() *Error {
+	if c.Ctx == nil || c.Ctx.Err() == nil {
+		return nil
+	}
+	return &Error{
+		Message: "execution stopped: " + c.Ctx.Err().Error(),
+		Err:     c.Ctx.Err(),
+	}
+}
+
+// Step counts an evaluation step, returning an error once
+// the step limit is exceeded or Ctx is done
+func (c *Context) Step() *Error {
+	steps := c.state().steps.Add(1)
+	if max := c.Limits.MaxSteps; max > 0 && steps > max {
+		return limitError(ErrStepLimit, "more than %d steps", max)
+	}
+	return c.Stopped()
+}
+
+// Enter counts a nested call, it must be paired with Leave
+// when it doesn't return an error
+func (c *Context) Enter() *Error {
+	max := c.Limits.MaxDepth
+	if max == 0 {
+		max = DefaultMaxDepth
+	}
+	if c.depth >= max {
+		return limitError(ErrDepthLimit, "more than %d nested calls", max)
+	}
+	c.depth++
+	return nil
+}
+
+func (c *Context) Leave() { c.depth-- }
+
+// Alloc counts n bytes allocated by the program
+func (c *Context) Alloc(n int64) *Error {
+	alloc := c.state().alloc.Add(n)
+	if max := c.Limits.MaxAlloc; max > 0 && alloc > max {
+		return limitError(ErrAllocLimit, "more than %d bytes", max)
+	}
+	return nil
+}
+
+func limitError(err error, format string, a ...any) *Error {
+	return &Error{Message: err.Error() + ": " + fmt.Sprintf(format, a...), Err: err}
+}
+
+// Modules caches loaded modules by their absolute path
+type Modules struct {
+	mu    sync.Mutex
+	loads map[string]*moduleLoad
+}
+
+// moduleLoad is a module being loaded, res is set once done is closed
+type moduleLoad struct {
+	done chan struct{}
+	res  Object
+}
+
+// Load returns the module cached at path, or the result of load.
+// an import of a module another one is loading waits for it, and
+// a failed load isn't cached so the next import retries it. the
+// imports waiting for a failed load each get a copy of its error
+func (m *Modules) Load(path string, c *Context, load func() Object) Object {
+	m.mu.Lock()
+	l, ok := m.loads[path]
+	if !ok {
+		if m.loads == nil {
+			m.loads = make(map[string]*moduleLoad)
+		}
+		l = &moduleLoad{done: make(chan struct{})}
+		m.loads[path] = l
+		m.mu.Unlock()
+		defer close(l.done)
+		res := load()
+		l.res = res
+		if err, failed := res.(*Error); failed {
+			// the stack of res grows as it unwinds through
+			// the importing calls, the waiters copy it as is
+			l.res = copyError(err)
+			m.mu.Lock()
+			delete(m.loads, path)
+			m.mu.Unlock()
+		}
+		return res
+	}
+	m.mu.Unlock()
+	select {
+	case <-l.done:
+		if err, failed := l.res.(*Error); failed {
+			return copyError(err)
+		}
+		return l.res
+	case <-c.Done():
+		return c.Stopped()
+	}
+}
+
+// copyError copies err along with its stack
+func copyError(err *Error) *Error {
+	copied := *err
+	copied.Stack = append([]Frame(nil), err.Stack...)
+	return &copied
+}
@@ -0,0 +1,154 @@
+package object
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrGeneratorClosed is wrapped by the error a yield returns when its
+// generator is closed, the body unwinds with it like with any error
+var ErrGeneratorClosed = errors.New("generator closed")
+
+// Generator runs the body of a generator function on its own goroutine,
+// each yield suspends the body until Next is called again, so a yield
+// resumes where it left off whatever block or function it is in.
+type Generator struct {
+	Fn *Function
+
+	// runs the body in env, returning its final value
+	body func(env *Env) Object
+	env  *Env
+
+	mu sync.Mutex
+	// the body waits on resume after each yield
+	resume chan struct{}
+	// closed by Close to wake up a suspended body
+	closed chan struct{}
+	// Next waits on steps for the body to yield or finish
+	steps   chan step
+	started bool
+	running bool
+	done    bool
+	result  Object
+
+	turnMu sync.Mutex
+	// closed once the last call to take its turn is done
+	lastTurn chan struct{}
+}
+
+type step struct {
+	value Object
+	done  bool
+}
+
+// NewGenerator returns a generator that runs body in env once Next is
+// called, yields in body (and in functions defined in it) suspend it
+func NewGenerator(fn *Function, env *Env, body func(env *Env) Object) *Generator {
+	g := &Generator{
+		Fn:     fn,
+		body:   body,
+		env:    env,
+		resume: make(chan struct{}),
+		closed: make(chan struct{}),
+		steps:  make(chan step),
+	}
+	env.gen = g
+	return g
+}
+
+func (g *Generator) Type() ObjectType { return GEN_OBJ }
+func (g *Generator) Inspect() string {
+	if g.Fn.Name == "" {
+		return "<generator>"
+	}
+	return "<generator " + g.Fn.Name + ">"
+}
+
+// Next runs the body until its next yield, returning an *Iteration,
+// or the *Error the body failed with. Once the body is done, the
+// value it finished with is returned again with Done set.
+func (g *Generator) Next() Object {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.done {
+		return g.finished()
+	}
+	g.running = true
+	if !g.started {
+		g.started = true
+		go g.run()
+	} else {
+		g.resume <- struct{}{}
+	}
+	s := <-g.steps
+	g.running = false
+	if s.done {
+		g.done = true
+		g.result = s.value
+		return g.finished()
+	}
+	return &Iteration{Val: s.value}
+}
+
+// Turn orders calls to Next made from several goroutines, ex: by the
+// tasks of an async generator. wait is closed once the calls that took
+// their turn before are done, done must be called after Next.
+func (g *Generator) Turn() (wait <-chan struct{}, done func()) {
+	g.turnMu.Lock()
+	defer g.turnMu.Unlock()
+	prev, cur := g.lastTurn, make(chan struct{})
+	g.lastTurn = cur
+	if prev == nil {
+		prev = make(chan struct{})
+		close(prev)
+	}
+	return prev, func() { close(cur) }
+}
+
+func (g *Generator) finished() Object {
+	if err, ok := g.result.(*Error); ok {
+		return err
+	}
+	return &Iteration{Done: true, Val: g.result}
+}
+
+// Close finishes g with result, a body suspended at a yield
+// is woken up to unwind so its goroutine can end. ex: a loop
+// over g exiting early. Close does nothing once g is done.
+func (g *Generator) Close(result Object) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.done {
+		return
+	}
+	g.done = true
+	g.result = result
+	close(g.closed)
+}
+
+func (g *Generator) run() {
+	res := g.body(g.env)
+	select {
+	case g.steps <- step{value: res, done: true}:
+	case <-g.closed:
+		// nobody waits for the body of a closed generator
+	}
+}
+
+// Yield hands val to the caller of Next and blocks until the
+// generator is resumed, it must be called by the body only.
+// It fails when the generator isn't running, ex: when a function
+// defined in the body is called after it returned, or when the
+// generator is closed while suspended.
+func (g *Generator) Yield(val Object) *Error {
+	if !g.running {
+		return &Error{Message: "yield outside of a generator"}
+	}
+	g.steps <- step{value: val}
+	select {
+	case <-g.resume:
+		return nil
+	case <-g.closed:
+		return &Error{Message: ErrGeneratorClosed.Error(), Err: ErrGeneratorClosed}
+	}
+}
@@ -0,0 +1,438 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"runtime/debug"
+	"strings"
+
+	"bariq/sched"
+)
+
+var (
+	objectType  = reflect.TypeOf((*Object)(nil)).Elem()
+	contextType = reflect.TypeOf((*Context)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType  = reflect.TypeOf(big.Int{})
+)
+
+// FromGo converts a Go value to an object: bools, ints, big.Ints, floats,
+// strings, slices, arrays, maps, structs, pointers to them and funcs. Struct
+// fields become hash keys named by their `bariq:"name"` tag, or by
+// their name, `bariq:"-"` skips a field. Funcs are wrapped in a
+// Builtin converting their args with ToGo, a func may take the
+// *Context first and may return an error last.
+func FromGo(v any) (Object, error) {
+	if v == nil {
+		return NULL, nil
+	}
+	if obj, ok := v.(Object); ok {
+		return obj, nil
+	}
+	return fromValue(reflect.ValueOf(v))
+}
+
+func fromValue(v reflect.Value) (Object, error) {
+	if v.Type().Implements(objectType) && v.CanInterface() {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(Object), nil
+	}
+	if v.Type() == bigIntType {
+		n := v.Interface().(big.Int)
+		return NewInt(new(big.Int).Set(&n)), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return &BigInt{Value: new(big.Int).SetUint64(v.Uint())}, nil
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			el, err := fromValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		pairs := make(map[HashKey]HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := setPair(pairs, iter.Key(), iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return &Hash{Pairs: pairs}, nil
+	case reflect.Struct:
+		pairs := make(map[HashKey]HashPair)
+		for _, f := range structFields(v.Type()) {
+			if err := setPair(pairs, reflect.ValueOf(f.name), v.Field(f.index)); err != nil {
+				return nil, err
+			}
+		}
+		return &Hash{Pairs: pairs}, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return wrapFunc(v), nil
+	}
+	return nil, fmt.Errorf("object: unsupported Go type %s", v.Type())
+}
+
+func setPair(pairs map[HashKey]HashPair, k, v reflect.Value) error {
+	key, err := fromValue(k)
+	if err != nil {
+		return err
+	}
+	hashable, ok := key.(Hashable)
+	if !ok {
+		return fmt.Errorf("object: unusable as hash key: %s", key.Type())
+	}
+	val, err := fromValue(v)
+	if err != nil {
+		return err
+	}
+	pairs[hashable.HashKey()] = HashPair{Key: key, Value: val}
+	return nil
+}
+
+type field struct {
+	name  string
+	index int
+}
+
+// structFields lists the exported fields of t with their names
+func structFields(t reflect.Type) []field {
+	fields := []field{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("bariq"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag, _, _ = strings.Cut(tag, ","); tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, field{name: name, index: i})
+	}
+	return fields
+}
+
+// wrapFunc wraps a Go func in a Builtin, a panic of
+// the func is returned as an error wrapping a *sched.PanicError
+func wrapFunc(fn reflect.Value) *Builtin {
+	t := fn.Type()
+	return &Builtin{Fn: func(ctx *Context, args ...Object) (res Object) {
+		defer func() {
+			if r := recover(); r != nil {
+				err := &sched.PanicError{Value: r, Stack: debug.Stack()}
+				res = &Error{Message: err.Error(), Err: err}
+			}
+		}()
+		in := []reflect.Value{}
+		params := t.NumIn()
+		first := 0
+		if params > 0 && t.In(0) == contextType {
+			in = append(in, reflect.ValueOf(ctx))
+			first = 1
+		}
+		want := params - first
+		if t.IsVariadic() {
+			if len(args) < want-1 {
+				return wrongArgs(len(args), want-1)
+			}
+		} else if len(args) != want {
+			return wrongArgs(len(args), want)
+		}
+		for i, arg := range args {
+			var pt reflect.Type
+			if t.IsVariadic() && first+i >= params-1 {
+				pt = t.In(params - 1).Elem()
+			} else {
+				pt = t.In(first + i)
+			}
+			v := reflect.New(pt)
+			if err := toValue(arg, v.Elem()); err != nil {
+				return &Error{Message: fmt.Sprintf("argument %d: %s", i+1, err)}
+			}
+			in = append(in, v.Elem())
+		}
+		out := fn.Call(in)
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err, _ := out[n-1].Interface().(error); err != nil {
+				return &Error{Message: err.Error()}
+			}
+			out = out[:n-1]
+		}
+		if len(out) == 0 {
+			return NULL
+		}
+		res, err := fromValue(out[0])
+		if err != nil {
+			return &Error{Message: err.Error()}
+		}
+		return res
+	}}
+}
+
+func wrongArgs(got, want int) *Error {
+	return &Error{Message: fmt.Sprintf("wrong number of args, got %d, want %d", got, want)}
+}
+
+// ToGo converts obj into the value target points to, following the
+// rules of FromGo backwards. When target points to an interface,
+// the natural Go value is stored: int64, *big.Int, float64, string, bool, nil, []any,
+// and map[string]any for hashes keyed by strings, or map[any]any.
+func ToGo(obj Object, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("object: ToGo needs a non nil pointer, got %T", target)
+	}
+	return toValue(obj, v.Elem())
+}
+
+func toValue(obj Object, v reflect.Value) error {
+	if obj == nil {
+		// ex: an unset element of a hash made by a host
+		obj = NULL
+	}
+	t := v.Type()
+	// an empty interface gets the natural value below
+	generic := t.Kind() == reflect.Interface && t.NumMethod() == 0
+	if !generic && reflect.TypeOf(obj).AssignableTo(t) {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+	if obj == NULL {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+	}
+	if t == bigIntType {
+		if n, ok := ToBig(obj); ok {
+			v.Set(reflect.ValueOf(*new(big.Int).Set(n)))
+			return nil
+		}
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		if !generic {
+			break
+		}
+		natural, err := naturalGo(obj)
+		if err != nil {
+			return err
+		}
+		if natural == nil {
+			v.Set(reflect.Zero(t))
+		} else {
+			v.Set(reflect.ValueOf(natural))
+		}
+		return nil
+	case reflect.Pointer:
+		p := reflect.New(t.Elem())
+		if err := toValue(obj, p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			v.SetBool(b.Value)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*Integer); ok {
+			if v.OverflowInt(i.Value) {
+				return fmt.Errorf("object: %d overflows %s", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return nil
+		}
+		if i, ok := obj.(*BigInt); ok {
+			return fmt.Errorf("object: %s overflows %s", i.Value, t)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*Integer); ok {
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return fmt.Errorf("object: %d overflows %s", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return nil
+		}
+		if i, ok := obj.(*BigInt); ok {
+			if !i.Value.IsUint64() || v.OverflowUint(i.Value.Uint64()) {
+				return fmt.Errorf("object: %s overflows %s", i.Value, t)
+			}
+			v.SetUint(i.Value.Uint64())
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		// integers convert to floats like in arithmetic
+		switch n := obj.(type) {
+		case *Float:
+			v.SetFloat(n.Value)
+			return nil
+		case *Integer:
+			v.SetFloat(float64(n.Value))
+			return nil
+		case *BigInt:
+			f, _ := new(big.Float).SetInt(n.Value).Float64()
+			v.SetFloat(f)
+			return nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*String); ok {
+			v.SetString(s.Value)
+			return nil
+		}
+	case reflect.Slice, reflect.Array:
+		arr, ok := obj.(*Array)
+		if !ok {
+			break
+		}
+		if t.Kind() == reflect.Array && t.Len() != len(arr.Elements) {
+			return fmt.Errorf("object: cannot convert ARRAY of length %d to %s", len(arr.Elements), t)
+		}
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements)))
+		}
+		for i, el := range arr.Elements {
+			if err := toValue(el, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			break
+		}
+		m := reflect.MakeMapWithSize(t, len(hash.Pairs))
+		for _, pair := range hash.Pairs {
+			k := reflect.New(t.Key()).Elem()
+			if err := toValue(pair.Key, k); err != nil {
+				return err
+			}
+			val := reflect.New(t.Elem()).Elem()
+			if err := toValue(pair.Value, val); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, val)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Struct:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			break
+		}
+		// keys without a field are ignored
+		for _, f := range structFields(t) {
+			pair, ok := hash.Pairs[(&String{Value: f.name}).HashKey()]
+			if !ok {
+				continue
+			}
+			if err := toValue(pair.Value, v.Field(f.index)); err != nil {
+				return fmt.Errorf("field %s: %w", f.name, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("object: cannot convert %s to %s", obj.Type(), t)
+}
+
+// naturalGo is the Go value obj converts to when
+// the target type doesn't ask for a specific one
+func naturalGo(obj Object) (any, error) {
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Integer:
+		return obj.Value, nil
+	case *BigInt:
+		return new(big.Int).Set(obj.Value), nil
+	case *Float:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *Array:
+		res := make([]any, len(obj.Elements))
+		for i, el := range obj.Elements {
+			v, err := naturalGo(el)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = v
+		}
+		return res, nil
+	case *Hash:
+		byString := true
+		for _, pair := range obj.Pairs {
+			if _, ok := pair.Key.(*String); !ok {
+				byString = false
+			}
+		}
+		if byString {
+			res := make(map[string]any, len(obj.Pairs))
+			for _, pair := range obj.Pairs {
+				v, err := naturalGo(pair.Value)
+				if err != nil {
+					return nil, err
+				}
+				res[pair.Key.(*String).Value] = v
+			}
+			return res, nil
+		}
+		res := make(map[any]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			k, _ := naturalGo(pair.Key)
+			v, err := naturalGo(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			res[k] = v
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("object: cannot convert %s to a Go value", obj.Type())
+}
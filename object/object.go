@@ -4,28 +4,41 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
 	"sync"
 
 	"bariq/ast"
+	"bariq/code"
 	"bariq/sched"
+	"bariq/token"
 )
 
 const (
 	HASH_OBJ         = "HASH"
 	INT_OBJ          = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	STRING_OBJ       = "STRING"
 	BOOL_OBJ         = "BOOLEAN"
 	NULL_OBJ         = "NULL"
-	YIELD_VALUE_OBJ  = "YIELD_VALUE"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	TASK_OBJ         = "TASK_OBJ"
 	GEN_OBJ          = "GEN_OBJ"
 	ITER_OBJ         = "ITER_OBJ"
+	CHANNEL_OBJ      = "CHANNEL"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ARRAY_OBJ        = "ARRAY"
 	BUILTIN_OBJ      = "BUILTIN"
+	MODULE_OBJ       = "MODULE"
+	QUOTE_OBJ        = "QUOTE"
+	MACRO_OBJ        = "MACRO"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 )
 
 type ObjectType string
@@ -53,6 +66,28 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey of a float equal to an integer is the key of that
+// integer, so 1.0 and 1 are the same key like 1.0 == 1 is true
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		n, _ := big.NewFloat(f.Value).Int(nil)
+		return NewInt(n).(Hashable).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
+// HashKey of a BigInt has its own key type, so it doesn't
+// collide with the keys of the integers fitting in an int64
+func (i *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write(i.Value.Bytes())
+	key := h.Sum64()
+	if i.Value.Sign() < 0 {
+		key = ^key
+	}
+	return HashKey{Type: "BIG_" + i.Type(), Value: key}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -68,6 +103,8 @@ type HashPair struct {
 }
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	// a frozen hash can't be changed, neither can its values
+	Frozen bool
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -86,7 +123,7 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
-type BuiltinFunction func(args ...Object) Object
+type BuiltinFunction func(ctx *Context, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -102,22 +139,7 @@ type Iteration struct {
 
 func (f *Iteration) Type() ObjectType { return GEN_OBJ }
 func (f *Iteration) Inspect() string {
-	var out bytes.Buffer
-	return out.String()
-}
-
-type Generator struct {
-	Env   *Env
-	Fn    *Function
-	Index int
-	Done  bool
-	Value Object
-}
-
-func (g *Generator) Type() ObjectType { return GEN_OBJ }
-func (g *Generator) Inspect() string {
-	var out bytes.Buffer
-	return out.String()
+	return fmt.Sprintf("{value: %s, done: %t}", f.Val.Inspect(), f.Done)
 }
 
 type Task struct {
@@ -125,12 +147,11 @@ type Task struct {
 }
 
 func (f *Task) Type() ObjectType { return TASK_OBJ }
-func (f *Task) Inspect() string {
-	var out bytes.Buffer
-	return out.String()
-}
+func (f *Task) Inspect() string  { return "<task>" }
 
 type Function struct {
+	// the name it was first bound to by let, empty if anonymous
+	Name       string
 	Parameters []*ast.Ident
 	Body       *ast.BlockStmt
 	Env        *Env
@@ -155,10 +176,91 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// Quote holds an unevaluated piece of code
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string  { return "QUOTE(" + q.Node.String() + ")" }
+
+type Macro struct {
+	Parameters []*ast.Ident
+	Body       *ast.BlockStmt
+	Env        *Env
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+	var out bytes.Buffer
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+	out.WriteString("macro")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+	return out.String()
+}
+
+// Module is an imported file, its top level
+// bindings are the ones living in Env
+type Module struct {
+	Name string
+	Path string
+	Env  *Env
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "<module " + m.Name + ">" }
+
+// CompiledFunction is a function compiled to bytecode by the compiler
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	// the name it was bound to by let, empty if anonymous
+	Name string
+	// positions of the instructions that can fail, by offset
+	Positions map[int]token.Position
+	// names of the called functions, by offset of the call
+	CallNames map[int]string
+	// names of the local and of the free variables, by index
+	LocalNames []string
+	FreeNames  []string
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a compiled function along with
+// the free variables it captured
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+// a closure is reported as a function so errors
+// are the same with both backends
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
 type Env struct {
 	mu    sync.RWMutex
 	store map[string]Object
-	outer *Env
+	// the statements declaring the const bindings of store
+	consts map[string]ast.Node
+	outer  *Env
+	// set on the env of a generator call,
+	// it is where a yield in its body goes
+	gen *Generator
 }
 
 func NewEnclosedEnv(outer *Env) *Env {
@@ -187,6 +289,38 @@ func (e *Env) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Generator is the generator a yield evaluated in e suspends,
+// nil when e isn't inside the body of a generator function
+func (e *Env) Generator() *Generator {
+	for ; e != nil; e = e.outer {
+		if e.gen != nil {
+			return e.gen
+		}
+	}
+	return nil
+}
+
+// Declare binds name in e like Set, constant makes the binding
+// const. it fails when name is a const of e declared by another
+// statement than decl, a loop body running decl again rebinds it.
+func (e *Env) Declare(name string, val Object, decl ast.Node, constant bool) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if d, ok := e.consts[name]; ok && d != decl {
+		return false
+	}
+	e.store[name] = val
+	if constant {
+		if e.consts == nil {
+			e.consts = make(map[string]ast.Node)
+		}
+		e.consts[name] = decl
+	} else {
+		delete(e.consts, name)
+	}
+	return true
+}
+
 func (e *Env) Set(name string, val Object) Object {
 	e.mu.Lock()
 	e.store[name] = val
@@ -194,19 +328,83 @@ func (e *Env) Set(name string, val Object) Object {
 	return val
 }
 
+// Update sets the binding of name in the innermost env having it
+// to fn(old value), unless fn returns an error, fn runs with that
+// env locked. ok is false when no env binds name, updating a
+// const gives an error.
+func (e *Env) Update(name string, fn func(old Object) Object) (val Object, ok bool) {
+	for ; e != nil; e = e.outer {
+		e.mu.Lock()
+		old, found := e.store[name]
+		if found {
+			if _, isConst := e.consts[name]; isConst {
+				val = &Error{Message: "cannot assign to const " + name}
+			} else {
+				val = fn(old)
+			}
+			if _, isErr := val.(*Error); !isErr {
+				e.store[name] = val
+			}
+			e.mu.Unlock()
+			return val, true
+		}
+		e.mu.Unlock()
+	}
+	return nil, false
+}
+
 type Error struct {
 	Message string
+	// where the error was raised, zero if unknown
+	Pos token.Position
+	// calls the error unwound through, innermost first
+	Stack []Frame
+	// the Go error behind it if any, ex: ErrStepLimit
+	Err error
 }
 
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
-func (e *Error) Type() ObjectType { return ERROR_OBJ }
+// Frame is a function call an error propagated through
+type Frame struct {
+	Name string         // name of the called function
+	Pos  token.Position // the call site
+}
 
-type YieldValue struct {
-	Value Object
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+
+// Traceback renders the error along with its call stack, outermost call
+// first, consecutive identical frames (ex: recursion) are collapsed.
+func (e *Error) Traceback() string {
+	if len(e.Stack) == 0 {
+		return e.Inspect()
+	}
+	var out bytes.Buffer
+	out.WriteString("Traceback (most recent call last):\n")
+	for i := len(e.Stack) - 1; i >= 0; {
+		f := e.Stack[i]
+		n := 1
+		for i-n >= 0 && e.Stack[i-n] == f {
+			n++
+		}
+		fmt.Fprintf(&out, "  %s: call to %s\n", f.Pos, f.Name)
+		if n > 1 {
+			fmt.Fprintf(&out, "  [previous call repeated %d more times]\n", n-1)
+		}
+		i -= n
+	}
+	out.WriteString(e.Inspect())
+	return out.String()
 }
+func (e *Error) Type() ObjectType { return ERROR_OBJ }
+
+// Error lets hosts handle errors of scripts as Go errors
+func (e *Error) Error() string { return e.Inspect() }
 
-func (yv *YieldValue) Inspect() string  { return yv.Value.Inspect() }
-func (yv *YieldValue) Type() ObjectType { return YIELD_VALUE_OBJ }
+func (e *Error) Unwrap() error { return e.Err }
 
 type ReturnValue struct {
 	Value Object
@@ -215,8 +413,27 @@ type ReturnValue struct {
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
+// Break and Continue unwind the statements of a loop
+// body up to the loop like ReturnValue does for functions
+type (
+	Break    struct{}
+	Continue struct{}
+)
+
+func (*Break) Inspect() string     { return "break" }
+func (*Break) Type() ObjectType    { return BREAK_OBJ }
+func (*Continue) Inspect() string  { return "continue" }
+func (*Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+var (
+	BREAK    = &Break{}
+	CONTINUE = &Continue{}
+)
+
 type Array struct {
 	Elements []Object
+	// a frozen array can't be changed, neither can its elements
+	Frozen bool
 }
 
 func (arr *Array) Inspect() string {
@@ -246,6 +463,53 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INT_OBJ }
 
+// BigInt is an integer that doesn't fit in an int64, its type is
+// INTEGER too, arithmetic on integers gives a BigInt on overflow
+type BigInt struct {
+	Value *big.Int
+}
+
+func (i *BigInt) Inspect() string  { return i.Value.String() }
+func (i *BigInt) Type() ObjectType { return INT_OBJ }
+
+// NewInt is an Integer if n fits in an int64, a BigInt otherwise
+func NewInt(n *big.Int) Object {
+	if n.IsInt64() {
+		return &Integer{Value: n.Int64()}
+	}
+	return &BigInt{Value: n}
+}
+
+// ToBig is the value of an Integer or a BigInt
+func ToBig(obj Object) (*big.Int, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value), true
+	case *BigInt:
+		return obj.Value, true
+	}
+	return nil, false
+}
+
+type Float struct {
+	Value float64
+}
+
+// Inspect always shows a dot or an exponent, so
+// floats can be told apart from integers
+func (f *Float) Inspect() string {
+	format := byte('f')
+	if abs := math.Abs(f.Value); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	s := strconv.FormatFloat(f.Value, format, -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
 type Boolean struct {
 	Value bool
 }
@@ -253,6 +517,13 @@ type Boolean struct {
 func (i *Boolean) Inspect() string  { return fmt.Sprintf("%t", i.Value) }
 func (i *Boolean) Type() ObjectType { return BOOL_OBJ }
 
+// the only null and booleans, they are compared by pointer
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
 type Null struct{}
 
 func (i *Null) Inspect() string  { return "null" }
@@ -1,8 +1,16 @@
 package object
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"bariq/sched"
 )
 
 func TestStringHashKey(t *testing.T) {
@@ -22,3 +30,290 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with the different Value have same hashes")
 	}
 }
+
+func TestFloat(t *testing.T) {
+	inspects := map[float64]string{
+		1:       "1.0",
+		-2.5:    "-2.5",
+		0.1:     "0.1",
+		1e21:    "1e+21",
+		1.5e-7:  "1.5e-07",
+		1234567: "1234567.0",
+	}
+	for v, want := range inspects {
+		if got := (&Float{Value: v}).Inspect(); got != want {
+			t.Errorf("Inspect of %v: want %s, got %s", v, want, got)
+		}
+	}
+	if (&Float{Value: 2}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("2.0 and 2 should be the same hash key")
+	}
+	huge := &BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 64)}
+	if (&Float{Value: 1 << 64}).HashKey() != huge.HashKey() {
+		t.Errorf("2.0 ** 64 and 2 ** 64 should be the same hash key")
+	}
+	if huge.HashKey() == (&BigInt{Value: new(big.Int).Neg(huge.Value)}).HashKey() {
+		t.Errorf("opposite big ints have the same hash key")
+	}
+	if (&Float{Value: 2.5}).HashKey() == (&Float{Value: 3.5}).HashKey() {
+		t.Errorf("floats with different values have the same hash key")
+	}
+}
+
+type point struct {
+	X      int    `bariq:"x"`
+	Y      int    `bariq:"y"`
+	Label  string `bariq:"label"`
+	Hidden string `bariq:"-"`
+	secret int
+}
+
+func TestFromGo(t *testing.T) {
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{nil, "null"},
+		{42, "42"},
+		{uint8(7), "7"},
+		{"hi", "hi"},
+		{true, "true"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{&point{X: 1, Y: 2, Label: "p", Hidden: "h"}, "{label: p, x: 1, y: 2}"},
+		{[]any{1, "a", nil}, "[1, a, null]"},
+		{[]float32{0.5, 2}, "[0.5, 2.0]"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{new(big.Int).Lsh(big.NewInt(1), 70), "1180591620717411303424"},
+		{big.NewInt(5), "5"},
+		{&Integer{Value: 3}, "3"},
+	}
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v): unexpected error: %s", tt.input, err)
+			continue
+		}
+		if got := sortedInspect(obj); got != tt.expected {
+			t.Errorf("FromGo(%#v): want %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// sortedInspect is Inspect with the pairs of hashes sorted
+func sortedInspect(obj Object) string {
+	hash, ok := obj.(*Hash)
+	if !ok {
+		return obj.Inspect()
+	}
+	pairs := []string{}
+	for _, p := range hash.Pairs {
+		pairs = append(pairs, p.Key.Inspect()+": "+sortedInspect(p.Value))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func TestFromGoErrors(t *testing.T) {
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{make(chan int), "object: unsupported Go type chan int"},
+		{map[any]int{[1]int{1}: 1}, "object: unusable as hash key: ARRAY"},
+		{[]complex64{1}, "object: unsupported Go type complex64"},
+	}
+	for _, tt := range tests {
+		_, err := FromGo(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("FromGo(%#v): want error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	hash, _ := FromGo(map[string]any{"x": 3, "label": "p", "extra": true})
+	var p point
+	if err := ToGo(hash, &p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if p.X != 3 || p.Label != "p" {
+		t.Errorf("wrong struct, got %+v", p)
+	}
+
+	arr, _ := FromGo([]int{1, 2, 3})
+	var ints []int8
+	if err := ToGo(arr, &ints); err != nil || len(ints) != 3 || ints[2] != 3 {
+		t.Errorf("wrong slice %v, err: %v", ints, err)
+	}
+
+	var natural any
+	if err := ToGo(hash, &natural); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	m, ok := natural.(map[string]any)
+	if !ok || m["x"] != int64(3) || m["extra"] != true {
+		t.Errorf("wrong natural value, got %#v", natural)
+	}
+
+	var f float64
+	if err := ToGo(&Integer{Value: 2}, &f); err != nil || f != 2 {
+		t.Errorf("wrong float %v, err: %v", f, err)
+	}
+	if err := ToGo(&Float{Value: 1.5}, &natural); err != nil || natural != 1.5 {
+		t.Errorf("wrong natural float %#v, err: %v", natural, err)
+	}
+
+	huge := &BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 64)}
+	var b *big.Int
+	if err := ToGo(huge, &b); err != nil || b.Cmp(huge.Value) != 0 {
+		t.Errorf("wrong big.Int %v, err: %v", b, err)
+	}
+	var u64 uint64
+	if err := ToGo(&BigInt{Value: new(big.Int).SetUint64(math.MaxUint64)}, &u64); err != nil || u64 != math.MaxUint64 {
+		t.Errorf("wrong uint64 %v, err: %v", u64, err)
+	}
+
+	var ptr *int
+	if err := ToGo(NULL, &ptr); err != nil || ptr != nil {
+		t.Errorf("null should convert to a nil pointer, got %v, err: %v", ptr, err)
+	}
+	// a nil object converts like null
+	ptr = new(int)
+	if err := ToGo(nil, &ptr); err != nil || ptr != nil {
+		t.Errorf("nil should convert to a nil pointer, got %v, err: %v", ptr, err)
+	}
+	if err := ToGo(nil, &natural); err != nil || natural != nil {
+		t.Errorf("nil should convert to a nil interface, got %#v, err: %v", natural, err)
+	}
+	var n int
+	if err := ToGo(nil, &n); err == nil || err.Error() != "object: cannot convert NULL to int" {
+		t.Errorf("wrong error for nil, got %v", err)
+	}
+	var obj Object
+	if err := ToGo(arr, &obj); err != nil || obj != arr {
+		t.Errorf("objects should be kept as they are, got %v, err: %v", obj, err)
+	}
+}
+
+func TestToGoErrors(t *testing.T) {
+	var (
+		i8  int8
+		u   uint
+		s   string
+		arr [2]int
+		n   int
+	)
+	tests := []struct {
+		obj      Object
+		target   any
+		expected string
+	}{
+		{&Integer{Value: 300}, &i8, "object: 300 overflows int8"},
+		{&Integer{Value: -1}, &u, "object: -1 overflows uint"},
+		{&Integer{Value: 1}, &s, "object: cannot convert INTEGER to string"},
+		{&Array{Elements: []Object{}}, &arr, "object: cannot convert ARRAY of length 0 to [2]int"},
+		{NULL, &n, "object: cannot convert NULL to int"},
+		{&Float{Value: 1.5}, &n, "object: cannot convert FLOAT to int"},
+		{&BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 64)}, &n, "object: 18446744073709551616 overflows int"},
+		{&Integer{Value: 1}, n, "object: ToGo needs a non nil pointer, got int"},
+	}
+	for _, tt := range tests {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("ToGo(%s): want error %q, got %v", tt.obj.Inspect(), tt.expected, err)
+		}
+	}
+}
+
+func TestFromGoFunc(t *testing.T) {
+	obj, err := FromGo(func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	div := obj.(*Builtin)
+	ctx := NewContext()
+	tests := []struct {
+		args     []Object
+		expected string
+	}{
+		{[]Object{&Integer{Value: 6}, &Integer{Value: 3}}, "2"},
+		{[]Object{&Integer{Value: 6}, &Integer{Value: 0}}, "ERROR: division by zero"},
+		{[]Object{&Integer{Value: 6}}, "ERROR: wrong number of args, got 1, want 2"},
+		{[]Object{&Integer{Value: 6}, &String{Value: "a"}}, "ERROR: argument 2: object: cannot convert STRING to int"},
+	}
+	for _, tt := range tests {
+		if got := div.Fn(ctx, tt.args...).Inspect(); got != tt.expected {
+			t.Errorf("want %s, got %s", tt.expected, got)
+		}
+	}
+
+	var out strings.Builder
+	ctx.Stdout = &out
+	obj, _ = FromGo(func(ctx *Context, parts ...string) {
+		fmt.Fprint(ctx.Stdout, strings.Join(parts, "-"))
+	})
+	res := obj.(*Builtin).Fn(ctx, &String{Value: "a"}, &String{Value: "b"})
+	if res != NULL || out.String() != "a-b" {
+		t.Errorf("variadic func with context wrongly called, got %s and %q", res.Inspect(), out.String())
+	}
+
+	// a panic is returned as an error
+	obj, _ = FromGo(func(xs []int) int { return xs[len(xs)] })
+	res = obj.(*Builtin).Fn(ctx, &Array{Elements: []Object{&Integer{Value: 1}}})
+	errObj, ok := res.(*Error)
+	var panicErr *sched.PanicError
+	if !ok || !errors.As(errObj, &panicErr) {
+		t.Fatalf("expected an error wrapping a panic, got %s", res.Inspect())
+	}
+	if errObj.Message != "panic: runtime error: index out of range [1] with length 1" {
+		t.Errorf("wrong error message, got %q", errObj.Message)
+	}
+}
+
+func TestModulesLoadError(t *testing.T) {
+	var m Modules
+	ctx := NewContext()
+	const waiters = 4
+	results := make(chan Object, waiters)
+	res := m.Load("mod", ctx, func() Object {
+		for i := 0; i < waiters; i++ {
+			go func() {
+				results <- m.Load("mod", ctx, func() Object {
+					return &Error{Message: "loaded again"}
+				})
+			}()
+		}
+		// lets the imports wait for this load
+		time.Sleep(10 * time.Millisecond)
+		return &Error{Message: "load failed"}
+	})
+	errs := []Object{res}
+	for i := 0; i < waiters; i++ {
+		errs = append(errs, <-results)
+	}
+	seen := map[*Error]bool{}
+	for _, res := range errs {
+		errObj, ok := res.(*Error)
+		if !ok {
+			t.Fatalf("expected an error, got %s", res.Inspect())
+		}
+		if seen[errObj] {
+			t.Fatalf("imports share the error %s", errObj.Inspect())
+		}
+		seen[errObj] = true
+		// what a failed import does to the error it returns
+		errObj.Stack = append(errObj.Stack, Frame{Name: "import"})
+	}
+	for _, res := range errs {
+		if stack := res.(*Error).Stack; len(stack) != 1 {
+			t.Errorf("expected a stack of 1 frame, got %v", stack)
+		}
+	}
+}
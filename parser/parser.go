@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 
 	"bariq/ast"
@@ -20,6 +22,10 @@ type Parser struct {
 	// prefix or infix functinon associated with it
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// loops around the current token in the current function,
+	// break and continue are only allowed inside them
+	loops int
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -27,6 +33,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdent)
 	p.registerPrefix(token.INT, p.parseIntLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpr)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpr)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -41,18 +48,35 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.IMPORT, p.parseImportExpr)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
+	p.registerPrefix(token.SELECT, p.parseSelectExpr)
+	p.registerPrefix(token.FOR, p.parseForExpr)
+	p.registerPrefix(token.WHILE, p.parseWhileExpr)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpr)
 	p.registerInfix(token.MINUS, p.parseInfixExpr)
 	p.registerInfix(token.GT, p.parseInfixExpr)
 	p.registerInfix(token.LT, p.parseInfixExpr)
+	p.registerInfix(token.GTE, p.parseInfixExpr)
+	p.registerInfix(token.LTE, p.parseInfixExpr)
+	p.registerInfix(token.AND, p.parseInfixExpr)
+	p.registerInfix(token.OR, p.parseInfixExpr)
 	p.registerInfix(token.SLASH, p.parseInfixExpr)
 	p.registerInfix(token.ASTERIK, p.parseInfixExpr)
+	p.registerInfix(token.PERCENT, p.parseInfixExpr)
+	p.registerInfix(token.POWER, p.parseInfixExpr)
 	p.registerInfix(token.EQ, p.parseInfixExpr)
 	p.registerInfix(token.NEQ, p.parseInfixExpr)
 	p.registerInfix(token.LPAREN, p.parseCallExpr)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpr)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpr)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpr)
+	p.registerInfix(token.ASTERIK_ASSIGN, p.parseAssignExpr)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpr)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpr)
+	p.registerInfix(token.DOT, p.parseDotExpr)
 	// read tow token so next and peek are set
 	p.nextToken()
 	p.nextToken()
@@ -69,6 +93,25 @@ func (p *Parser) parseIndexExpr(left ast.Expr) ast.Expr {
 	return exp
 }
 
+// a.b is sugar for a["b"]
+func (p *Parser) parseDotExpr(left ast.Expr) ast.Expr {
+	exp := &ast.IndexExpr{Token: p.curToken, Left: left}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Index = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
+}
+
+func (p *Parser) parseImportExpr() ast.Expr {
+	expr := &ast.ImportExpr{Token: p.curToken}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	expr.Path = p.curToken.Literal
+	return expr
+}
+
 func (p *Parser) parseCallExpr(function ast.Expr) ast.Expr {
 	exp := &ast.CallExpr{Token: p.curToken, Function: function}
 	exp.Args = p.parseExprList(token.RPAREN)
@@ -98,15 +141,12 @@ func (p *Parser) parseAsyncFunctionLiteral() ast.Expr {
 	if !p.expectPeek(token.FUNCTION) {
 		return nil
 	}
-	lit := &ast.FunctionLiteral{Async: true, Token: p.curToken}
-	if !p.expectPeek(token.LPAREN) {
+	// async fn gen () {} is an async generator
+	lit, ok := p.parseFunctionLiteral().(*ast.FunctionLiteral)
+	if !ok {
 		return nil
 	}
-	lit.Parameters = p.parseFunctionParams()
-	if !p.expectPeek(token.LBRACE) {
-		return nil
-	}
-	lit.Body = p.parseBlockStmt()
+	lit.Async = true
 	return lit
 }
 
@@ -123,8 +163,20 @@ func (p *Parser) parseFunctionLiteral() ast.Expr {
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
-	lit.Body = p.parseBlockStmt()
-	fmt.Println(lit.Gen)
+	lit.Body = p.parseFunctionBody()
+	return lit
+}
+
+func (p *Parser) parseMacroLiteral() ast.Expr {
+	lit := &ast.MacroLiteral{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	lit.Parameters = p.parseFunctionParams()
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	lit.Body = p.parseFunctionBody()
 	return lit
 }
 
@@ -155,7 +207,6 @@ func (p *Parser) parseYieldExpr() ast.Expr {
 	expr := &ast.YieldExpr{Token: p.curToken}
 	p.nextToken()
 	expr.Arg = p.parseCurrExpr(LOWEST)
-	fmt.Printf("yield expr: %v\n", expr)
 	return expr
 }
 
@@ -196,6 +247,151 @@ func (p *Parser) parseIfExpr() ast.Expr {
 	return expr
 }
 
+// parseFunctionBody parses the body of a function, the
+// loops around the function don't apply to it
+func (p *Parser) parseFunctionBody() *ast.BlockStmt {
+	loops := p.loops
+	p.loops = 0
+	defer func() { p.loops = loops }()
+	return p.parseBlockStmt()
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStmt {
+	p.loops++
+	defer func() { p.loops-- }()
+	return p.parseBlockStmt()
+}
+
+func (p *Parser) parseWhileExpr() ast.Expr {
+	expr := &ast.WhileExpr{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	expr.Condition = p.parseCurrExpr(LOWEST)
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expr.Body = p.parseLoopBody()
+	return expr
+}
+
+// for (x in xs) { ... }, for (k, v in xs) { ... }
+// or for await (x in gen) { ... }
+func (p *Parser) parseForExpr() ast.Expr {
+	expr := &ast.ForInExpr{Token: p.curToken}
+	if p.peekTokenIs(token.AWAIT) {
+		p.nextToken()
+		expr.Await = true
+	}
+	if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expr.Name = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expr.Key = expr.Name
+		expr.Name = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	expr.Iterable = p.parseCurrExpr(LOWEST)
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expr.Body = p.parseLoopBody()
+	return expr
+}
+
+// select {
+//
+//	case v, ok = recv(ch) { ... }
+//	case send(ch, x) { ... }
+//	case timeout(ms) { ... }
+//	default { ... }
+//
+// }
+func (p *Parser) parseSelectExpr() ast.Expr {
+	expr := &ast.SelectExpr{Token: p.curToken}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+	for !p.curTokenIs(token.RBRACE) {
+		switch p.curToken.Type {
+		case token.CASE:
+			c := p.parseSelectCase()
+			if c == nil {
+				return nil
+			}
+			expr.Cases = append(expr.Cases, c)
+		case token.DEFAULT:
+			if expr.Default != nil {
+				p.errorf(p.curToken.Pos, "select with several default cases")
+				return nil
+			}
+			if !p.expectPeek(token.LBRACE) {
+				return nil
+			}
+			expr.Default = p.parseBlockStmt()
+		default:
+			p.errorf(p.curToken.Pos, "expected case or default in select, got %s", p.curToken.Type)
+			return nil
+		}
+		p.nextToken()
+	}
+	return expr
+}
+
+func (p *Parser) parseSelectCase() *ast.SelectCase {
+	c := &ast.SelectCase{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	if p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.ASSIGN) {
+		c.Value = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			c.Ok = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+		}
+		if !p.expectPeek(token.ASSIGN) || !p.expectPeek(token.IDENT) {
+			return nil
+		}
+	}
+	c.Kind = p.curToken.Literal
+	pos := p.curToken.Pos
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	c.Args = p.parseExprList(token.RPAREN)
+	want := map[string]int{"recv": 1, "send": 2, "timeout": 1}
+	n, ok := want[c.Kind]
+	switch {
+	case !ok:
+		p.errorf(pos, "expected recv, send or timeout in a select case, got %s", c.Kind)
+		return nil
+	case len(c.Args) != n:
+		p.errorf(pos, "wrong number of args for %s in a select case, got %d, want %d", c.Kind, len(c.Args), n)
+		return nil
+	case c.Value != nil && c.Kind != "recv":
+		p.errorf(pos, "only recv binds names in a select case, got %s", c.Kind)
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	c.Body = p.parseBlockStmt()
+	return c
+}
+
 func (p *Parser) parseBlockStmt() *ast.BlockStmt {
 	block := &ast.BlockStmt{Token: p.curToken}
 	block.Stmts = []ast.Stmt{}
@@ -259,10 +455,42 @@ func (p *Parser) parseInfixExpr(left ast.Expr) ast.Expr {
 	// if exp.Operator == "+" {
 	// 	exp.Right = p.parseExpr(precedence - 1)
 	// }
+	// ** is right-associative, 2 ** 3 ** 2 is 2 ** (3 ** 2)
+	if exp.Operator == "**" {
+		precedence--
+	}
 	exp.Right = p.parseCurrExpr(precedence)
 	return exp
 }
 
+// parseAssignExpr parses the value with the lowest precedence,
+// so a = b = 1 assigns 1 to b then to a
+func (p *Parser) parseAssignExpr(left ast.Expr) ast.Expr {
+	exp := &ast.AssignExpr{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   left,
+	}
+	if !assignable(left) {
+		p.errorf(p.curToken.Pos, "cannot assign to %s", left.String())
+	}
+	p.nextToken()
+	exp.Value = p.parseCurrExpr(LOWEST)
+	return exp
+}
+
+// assignable is true for a name and for indexes of a name,
+// ex: x, x[0] or x.y[1]
+func assignable(exp ast.Expr) bool {
+	switch exp := exp.(type) {
+	case *ast.Ident:
+		return true
+	case *ast.IndexExpr:
+		return assignable(exp.Left)
+	}
+	return false
+}
+
 func (p *Parser) parsePrefixExpr() ast.Expr {
 	exp := &ast.PrefixExpr{
 		Token:    p.curToken,
@@ -317,9 +545,23 @@ func (p *Parser) parseStringLiteral() ast.Expr {
 func (p *Parser) parseIntLiteral() ast.Expr {
 	lit := &ast.IntLiteral{Token: p.curToken}
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		lit.Big, _ = new(big.Int).SetString(p.curToken.Literal, 0)
+		return lit
+	}
+	if err != nil {
+		p.errorf(p.curToken.Pos, "couldn't parse %q  as interger", p.curToken.Literal)
+		return nil
+	}
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expr {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		msg := fmt.Sprintf("couldn't parse %q  as interger", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken.Pos, "couldn't parse %q as float", p.curToken.Literal)
 		return nil
 	}
 	lit.Value = value
@@ -330,18 +572,27 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix function for %s found ", t)
+// errorf records an error prefixed with the
+// position it was found at, ex: main.bq:3:7: msg
+func (p *Parser) errorf(pos token.Position, format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	if pos.IsValid() {
+		msg = pos.String() + ": " + msg
+	}
 	p.errors = append(p.errors, msg)
 }
 
+func (p *Parser) noPrefixParseFnError(t token.TokenType) {
+	p.errorf(p.curToken.Pos, "no prefix function for %s found ", t)
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf(
+	p.errorf(
+		p.peekToken.Pos,
 		"expected the next token to be %s, got %s",
 		t,
 		p.peekToken.Type,
 	)
-	p.errors = append(p.errors, msg)
 }
 
 func (p *Parser) nextToken() {
@@ -364,10 +615,12 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 func (p *Parser) parseStatement() ast.Stmt {
 	switch p.curToken.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.parseLetStmt()
 	case token.RET:
 		return p.parseRetStmt()
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStmt()
 	default:
 		// fmt.Println(p.curToken.Type, p.curToken.Literal)
 		return p.parseExprStmt()
@@ -379,26 +632,42 @@ func (p *Parser) parseStatement() ast.Stmt {
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN
+	OR
+	AND
 	EQUALS
 	LESSGREETER
 	SUM
 	PRODUCT
 	PREFIX
+	POWER
 	CALL
 	INDEX
 )
 
 var precedence = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NEQ:      EQUALS,
-	token.MINUS:    SUM,
-	token.PLUS:     SUM,
-	token.GT:       LESSGREETER,
-	token.LT:       LESSGREETER,
-	token.SLASH:    PRODUCT,
-	token.ASTERIK:  PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:         ASSIGN,
+	token.PLUS_ASSIGN:    ASSIGN,
+	token.MINUS_ASSIGN:   ASSIGN,
+	token.ASTERIK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:   ASSIGN,
+	token.EQ:             EQUALS,
+	token.NEQ:            EQUALS,
+	token.MINUS:          SUM,
+	token.PLUS:           SUM,
+	token.GT:             LESSGREETER,
+	token.LT:             LESSGREETER,
+	token.GTE:            LESSGREETER,
+	token.LTE:            LESSGREETER,
+	token.OR:             OR,
+	token.AND:            AND,
+	token.SLASH:          PRODUCT,
+	token.ASTERIK:        PRODUCT,
+	token.PERCENT:        PRODUCT,
+	token.POWER:          POWER,
+	token.LPAREN:         CALL,
+	token.LBRACKET:       INDEX,
+	token.DOT:            INDEX,
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -425,8 +694,22 @@ func (p *Parser) parseRetStmt() *ast.ReturnStmt {
 	return stmt
 }
 
+func (p *Parser) parseLoopControlStmt() ast.Stmt {
+	tok := p.curToken
+	if p.loops == 0 {
+		p.errorf(tok.Pos, "%s outside of a loop", tok.Literal)
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	if tok.Type == token.BREAK {
+		return &ast.BreakStmt{Token: tok}
+	}
+	return &ast.ContinueStmt{Token: tok}
+}
+
 func (p *Parser) parseLetStmt() *ast.LetStmt {
-	stmt := &ast.LetStmt{Token: p.curToken}
+	stmt := &ast.LetStmt{Token: p.curToken, Const: p.curTokenIs(token.CONST)}
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
@@ -46,6 +46,26 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestConstStatement(t *testing.T) {
+	l := lexer.New("const x = 5; let y = x;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Stmts) != 2 {
+		t.Fatalf("expected 2 stmts but got %d", len(program.Stmts))
+	}
+	c, ok := program.Stmts[0].(*ast.LetStmt)
+	if !ok || !c.Const || c.Name.Value != "x" || !testLiteralExpr(t, c.Value, 5) {
+		t.Errorf("wrong const stmt, got %s", program.Stmts[0])
+	}
+	if c.String() != "const x = 5;" {
+		t.Errorf("wrong string, got %q", c.String())
+	}
+	if let, ok := program.Stmts[1].(*ast.LetStmt); !ok || let.Const {
+		t.Errorf("let stmt marked const, got %s", program.Stmts[1])
+	}
+}
+
 func testLetStmt(t *testing.T, s ast.Stmt, name string) bool {
 	if s.TokenLiteral() != "let" {
 		t.Errorf("s.TokenLiteral not 'let'. got %s ", s.TokenLiteral())
@@ -371,6 +391,34 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2],b[1],2 * [1,2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a.b.c(1) + d.e",
+			"(((a.b).c)(1) + (d.e))",
+		},
+		{
+			"a + b % c ** d ** -e * f",
+			"(a + ((b % (c ** (d ** (-e)))) * f))",
+		},
+		{
+			"-a ** b[0]",
+			"(-(a ** (b[0])))",
+		},
+		{
+			"a >= 0 && a <= 10 || !b == c",
+			"(((a >= 0) && (a <= 10)) || ((!b) == c))",
+		},
+		{
+			"x = a || b && c",
+			"(x = (a || (b && c)))",
+		},
+		{
+			"a = b = c + 1",
+			"(a = (b = (c + 1)))",
+		},
+		{
+			"a[i].b += x * 2 == y",
+			"(((a[i]).b) += ((x * 2) == y))",
+		},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -789,6 +837,36 @@ func TestCallExprParsing(t *testing.T) {
 	testInfixExpr(t, exp.Args[2], 4, "+", 5)
 }
 
+func TestFloatLiteralExpr(t *testing.T) {
+	l := lexer.New("2.5e-1;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Stmts[0].(*ast.ExprStmt)
+	lit, ok := stmt.Expr.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.FloatLiteral. got %T", stmt.Expr)
+	}
+	if lit.Value != 0.25 {
+		t.Errorf("lit.Value not 0.25. got %v", lit.Value)
+	}
+}
+
+func TestBigIntLiteralExpr(t *testing.T) {
+	l := lexer.New("123456789012345678901234567890;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Stmts[0].(*ast.ExprStmt)
+	lit, ok := stmt.Expr.(*ast.IntLiteral)
+	if !ok || lit.Big == nil {
+		t.Fatalf("exp not a big *ast.IntLiteral. got %#v", stmt.Expr)
+	}
+	if lit.Big.String() != "123456789012345678901234567890" {
+		t.Errorf("wrong value, got %s", lit.Big)
+	}
+}
+
 func TestStringLiteralExpr(t *testing.T) {
 	input := `"foobar";`
 	l := lexer.New(input)
@@ -1050,3 +1128,146 @@ func TestParsingHashLiteralWithExprs(t *testing.T) {
 		testFunc(v)
 	}
 }
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;", "1:5: expected the next token to be IDENT, got ="},
+		{"let x = 5;\nlet y 6;", "2:7: expected the next token to be =, got INT"},
+		{"fn(x {\n x }", "1:6: expected the next token to be ), got {"},
+		{"select { case get(c) {} }", "1:15: expected recv, send or timeout in a select case, got get"},
+		{"select { case v = send(c, 1) {} }", "1:19: only recv binds names in a select case, got send"},
+		{"select { case recv(c, 1) {} }", "1:15: wrong number of args for recv in a select case, got 2, want 1"},
+		{"select { 1 }", "1:10: expected case or default in select, got INT"},
+		{"for await (x of g) {}", "1:14: expected the next token to be IN, got IDENT"},
+		{"if (x) { break }", "1:10: break outside of a loop"},
+		{"f() = 1", "1:5: cannot assign to f()"},
+		{"1 += 2", "1:3: cannot assign to 1"},
+		{"while (x) { fn() { continue } }", "1:20: continue outside of a loop"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error, expected %q, got %q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestImportExpr(t *testing.T) {
+	input := `let m = import "lib/math.bq";`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Stmts) != 1 {
+		t.Fatalf("expected 1 stmts but got %d", len(program.Stmts))
+	}
+	stmt, ok := program.Stmts[0].(*ast.LetStmt)
+	if !ok {
+		t.Fatalf("s is not *ast.LetStmt. got %T", program.Stmts[0])
+	}
+	imp, ok := stmt.Value.(*ast.ImportExpr)
+	if !ok {
+		t.Fatalf("let value is not *ast.ImportExpr, got %T", stmt.Value)
+	}
+	if imp.Path != "lib/math.bq" {
+		t.Errorf("import path is not %q, got %q", "lib/math.bq", imp.Path)
+	}
+}
+
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Stmts) != 1 {
+		t.Fatalf("expected 1 stmts but got %d", len(program.Stmts))
+	}
+	stmt, ok := program.Stmts[0].(*ast.ExprStmt)
+	if !ok {
+		t.Fatalf("s is not *ast.exprStmt. got %T", program.Stmts[0])
+	}
+	macro, ok := stmt.Expr.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt expr is not MacroLiteral expr, got %T ", stmt.Expr)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("expected 2 params, got %d", len(macro.Parameters))
+	}
+	testLiteralExpr(t, macro.Parameters[0], "x")
+	testLiteralExpr(t, macro.Parameters[1], "y")
+	if len(macro.Body.Stmts) != 1 {
+		t.Fatalf("expected 1 stmt for macro body, got %d", len(macro.Body.Stmts))
+	}
+	bodyStmt, ok := macro.Body.Stmts[0].(*ast.ExprStmt)
+	if !ok {
+		t.Fatalf("macro body stmt is not an expr stmt. got %T", macro.Body.Stmts[0])
+	}
+	testInfixExpr(t, bodyStmt.Expr, "x", "+", "y")
+}
+
+func TestSelectExprParsing(t *testing.T) {
+	input := `select {
+	case v, ok = recv(c) { v }
+	case send(c, 1 + 2) { 1 }
+	case timeout(10) { 2 }
+	default { 3 }
+	}`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Stmts) != 1 {
+		t.Fatalf("expected 1 stmts but got %d", len(program.Stmts))
+	}
+	stmt, ok := program.Stmts[0].(*ast.ExprStmt)
+	if !ok {
+		t.Fatalf("s is not *ast.exprStmt. got %T", program.Stmts[0])
+	}
+	sel, ok := stmt.Expr.(*ast.SelectExpr)
+	if !ok {
+		t.Fatalf("stmt expr is not SelectExpr, got %T", stmt.Expr)
+	}
+	if len(sel.Cases) != 3 || sel.Default == nil {
+		t.Fatalf("expected 3 cases and a default, got %d cases", len(sel.Cases))
+	}
+	expected := "select {case v, ok = recv(c) v case send(c, (1 + 2)) 1 case timeout(10) 2 default 3}"
+	if sel.String() != expected {
+		t.Errorf("wrong select, want %q, got %q", expected, sel.String())
+	}
+}
+
+func TestForAwaitParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`for await (x in g(1)) { puts(x) }`, "for await (x in g(1)) puts(x)"},
+		{`async fn gen (n) { yield await(n) }`, "fn(n) yield await(n) "},
+		{`for (k, v in h) { continue; }`, "for (k, v in h) continue;"},
+		{`while (x < 1) { break }`, "while ((x < 1)) break;"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("want %q, got %q", tt.expected, program.String())
+		}
+	}
+	lit := New(lexer.New(`async fn gen () {}`)).ParseProgram().Stmts[0].(*ast.ExprStmt).Expr.(*ast.FunctionLiteral)
+	if !lit.Async || !lit.Gen {
+		t.Errorf("async generator not marked as async and gen, got %+v", lit)
+	}
+}
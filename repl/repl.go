@@ -1,7 +1,6 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 
@@ -14,15 +13,17 @@ import (
 const PROMPT = ">> "
 
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+	// programs print to out and read from in like the repl does,
+	// lines are read through ctx so `input()` gets the next one
+	ctx := &object.Context{Stdout: out, Stderr: out, Stdin: in, Clock: object.SystemClock}
 	env := object.NewEnv()
+	macroEnv := object.NewEnv()
 	for {
 		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := ctx.ReadLine()
+		if err != nil {
 			return
 		}
-		line := scanner.Text()
 		if line == "exit" {
 			return
 		}
@@ -33,7 +34,19 @@ func Start(in io.Reader, out io.Writer) {
 			printParseErrors(out, p.Errors())
 			continue
 		}
-		evaluated := evaluator.Eval(program, env)
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, errObj := evaluator.ExpandMacros(program, macroEnv, ctx)
+		if errObj != nil {
+			io.WriteString(out, errObj.Traceback())
+			io.WriteString(out, "\n")
+			continue
+		}
+		evaluated := evaluator.Eval(expanded, env, ctx)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			io.WriteString(out, errObj.Traceback())
+			io.WriteString(out, "\n")
+			continue
+		}
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
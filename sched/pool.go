@@ -0,0 +1,109 @@
+package sched
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultWorkers is the number of tasks a scheduler runs at once
+// unless told otherwise
+const DefaultWorkers = 1024
+
+// Default is the scheduler of Spawn and of its variants
+var Default = New(DefaultWorkers)
+
+// Scheduler runs tasks on a bounded pool of workers, tasks
+// submitted while every worker is busy wait in a run queue
+type Scheduler struct {
+	mu      sync.Mutex
+	limit   int
+	workers int
+	queue   []func()
+
+	queued    atomic.Int64
+	running   atomic.Int64
+	completed atomic.Int64
+}
+
+// Metrics counts the tasks of a scheduler
+type Metrics struct {
+	// waiting for a worker
+	Queued int64
+	// started but not finished
+	Running int64
+	// finished, cancelled ones included
+	Completed int64
+}
+
+// New returns a scheduler running up to workers tasks at once
+func New(workers int) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Scheduler{limit: workers}
+}
+
+// Workers is the number of tasks s runs at once
+func (s *Scheduler) Workers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// SetWorkers changes the number of tasks s runs at once, running
+// tasks aren't stopped when it goes down
+func (s *Scheduler) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = n
+	for s.workers < s.limit && len(s.queue) > 0 {
+		s.workers++
+		go s.work(s.pop())
+	}
+}
+
+func (s *Scheduler) Metrics() Metrics {
+	return Metrics{
+		Queued:    s.queued.Load(),
+		Running:   s.running.Load(),
+		Completed: s.completed.Load(),
+	}
+}
+
+func (s *Scheduler) submit(job func()) {
+	s.queued.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.workers < s.limit {
+		s.workers++
+		go s.work(job)
+		return
+	}
+	s.queue = append(s.queue, job)
+}
+
+// work runs job then the queued jobs until none is left
+func (s *Scheduler) work(job func()) {
+	for {
+		job()
+		s.mu.Lock()
+		if len(s.queue) == 0 || s.workers > s.limit {
+			s.workers--
+			s.mu.Unlock()
+			return
+		}
+		job = s.pop()
+		s.mu.Unlock()
+	}
+}
+
+// pop must be called with mu held
+func (s *Scheduler) pop() func() {
+	job := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return job
+}
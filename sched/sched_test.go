@@ -0,0 +1,102 @@
+package sched
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerBoundsWorkers(t *testing.T) {
+	s := New(2)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	tasks := []*Task[int]{}
+	for i := 0; i < 10; i++ {
+		i := i
+		tasks = append(tasks, Submit(s, context.Background(), func(context.Context) (int, error) {
+			if i < 2 {
+				wg.Done()
+			}
+			<-release
+			return i, nil
+		}))
+	}
+	wg.Wait()
+	if m := s.Metrics(); m.Running != 2 || m.Queued != 8 {
+		t.Errorf("want 2 running and 8 queued, got %+v", m)
+	}
+	close(release)
+	for i, task := range tasks {
+		if v, err := task.Await(); v != i || err != nil {
+			t.Errorf("task %d: got %d, %v", i, v, err)
+		}
+	}
+	if m := s.Metrics(); m.Completed != 10 || m.Queued != 0 || m.Running != 0 {
+		t.Errorf("want 10 completed, got %+v", m)
+	}
+}
+
+func TestAwaitRunsQueuedTasks(t *testing.T) {
+	s := New(1)
+	// the only worker awaits a task queued after it
+	outer := Submit(s, context.Background(), func(ctx context.Context) (int, error) {
+		inner := Submit(s, ctx, func(context.Context) (int, error) { return 2, nil })
+		v, err := inner.Await()
+		return v + 1, err
+	})
+	done := make(chan struct{})
+	go func() {
+		if v, err := outer.Await(); v != 3 || err != nil {
+			t.Errorf("want 3, got %d, %v", v, err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nested await deadlocked")
+	}
+}
+
+func TestCancelQueuedTask(t *testing.T) {
+	s := New(1)
+	release := make(chan struct{})
+	defer close(release)
+	Submit(s, context.Background(), func(context.Context) (int, error) {
+		<-release
+		return 0, nil
+	})
+	ran := false
+	task := Submit(s, context.Background(), func(context.Context) (int, error) {
+		ran = true
+		return 1, nil
+	})
+	task.Cancel()
+	if _, err := task.Await(); !errors.Is(err, context.Canceled) || ran {
+		t.Errorf("a task cancelled while queued shouldn't run, got %v, ran: %t", err, ran)
+	}
+}
+
+func TestSetWorkers(t *testing.T) {
+	s := New(1)
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	for i := 0; i < 3; i++ {
+		Submit(s, context.Background(), func(context.Context) (int, error) {
+			started <- struct{}{}
+			<-release
+			return 0, nil
+		})
+	}
+	<-started
+	s.SetWorkers(3)
+	<-started
+	<-started
+	if m := s.Metrics(); m.Running != 3 {
+		t.Errorf("want 3 running, got %+v", m)
+	}
+	close(release)
+}
@@ -3,6 +3,8 @@ package sched
 import (
 	"context"
 	"fmt"
+	"runtime/debug"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,20 +18,42 @@ type (
 	}
 )
 
+// the states of a task, a queued task is claimed
+// by a worker or by the first call to Await
+const (
+	queued int32 = iota
+	started
+)
+
 type Task[T any] struct {
-	respch       chan response[T]
+	// closed once resp is set
+	done         chan struct{}
+	resp         response[T]
+	state        atomic.Int32
+	job          func()
 	ctx          context.Context
 	cancel       context.CancelFunc
 	parentCancel context.CancelFunc
 }
 
+// Await waits for the task to finish or to be cancelled,
+// a task can be awaited several times. A task still queued
+// runs on the goroutine awaiting it, so tasks awaiting the
+// tasks they spawn don't wait for a free worker.
 func (t *Task[T]) Await() (T, error) {
+	t.job()
 	select {
+	case <-t.done:
+		return t.resp.value, t.resp.err
 	case <-t.ctx.Done():
+		// a task finishing before being cancelled keeps its result
+		select {
+		case <-t.done:
+			return t.resp.value, t.resp.err
+		default:
+		}
 		var val T
 		return val, t.ctx.Err()
-	case resp := <-t.respch:
-		return resp.value, resp.err
 	}
 }
 
@@ -42,44 +66,106 @@ func (t *Task[T]) Cancel() {
 	t.parentCancel()
 }
 
+// Err is nil until the task is cancelled or times out
+func (t *Task[T]) Err() error { return t.ctx.Err() }
+
 func SpawnWithTimeout[T any](
 	t TaskFunc[T],
 	d time.Duration,
 ) *Task[T] {
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		d,
-	)
-	return spawn(ctx, cancel, t)
+	return SpawnWithTimeoutContext(context.Background(), t, d)
+}
+
+// SpawnWithTimeoutContext is SpawnWithTimeout for a task
+// also cancelled when ctx is done
+func SpawnWithTimeoutContext[T any](
+	ctx context.Context,
+	t TaskFunc[T],
+	d time.Duration,
+) *Task[T] {
+	return SubmitWithTimeout(Default, ctx, t, d)
 }
 
 func Spawn[T any](t TaskFunc[T]) *Task[T] {
-	ctx := context.Background()
-	return spawn(ctx, func() {}, t)
+	return SpawnContext(context.Background(), t)
+}
+
+// SpawnContext is Spawn for a task cancelled when ctx is done
+func SpawnContext[T any](ctx context.Context, t TaskFunc[T]) *Task[T] {
+	return Submit(Default, ctx, t)
+}
+
+// Submit queues t on s, it is cancelled when ctx is done
+func Submit[T any](s *Scheduler, ctx context.Context, t TaskFunc[T]) *Task[T] {
+	return spawn(s, ctx, func() {}, t)
+}
+
+// SubmitWithTimeout is Submit for a task cancelled after d
+func SubmitWithTimeout[T any](
+	s *Scheduler,
+	ctx context.Context,
+	t TaskFunc[T],
+	d time.Duration,
+) *Task[T] {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return spawn(s, ctx, cancel, t)
+}
+
+// PanicError is the error of a task that panicked
+type PanicError struct {
+	Value any
+	// the stack of the task when it panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string { return fmt.Sprintf("panic: %v", e.Value) }
+
+// run calls t, turning a panic into a *PanicError
+func run[T any](c context.Context, t TaskFunc[T]) (val T, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return t(c)
 }
 
 func spawn[T any](
+	s *Scheduler,
 	ctx context.Context,
 	parentCancel context.CancelFunc,
 	t TaskFunc[T],
 ) *Task[T] {
-	respch := make(chan response[T])
 	// INFO: ctx here is the prarent context
 	c, cancel := context.WithCancel(ctx)
+	task := &Task[T]{
+		done:         make(chan struct{}),
+		ctx:          c,
+		cancel:       cancel,
+		parentCancel: parentCancel,
+	}
 
-	go func() {
-		val, err := t(c)
-		respch <- response[T]{
+	task.job = func() {
+		if !task.state.CompareAndSwap(queued, started) {
+			return
+		}
+		s.queued.Add(-1)
+		s.running.Add(1)
+		var val T
+		var err error
+		// a task cancelled while queued doesn't run
+		if err = c.Err(); err == nil {
+			val, err = run(c, t)
+		}
+		task.resp = response[T]{
 			value: val,
 			err:   err,
 		}
-		close(respch)
-	}()
-
-	return &Task[T]{
-		respch:       respch,
-		ctx:          c,
-		cancel:       cancel,
-		parentCancel: parentCancel,
+		close(task.done)
+		s.running.Add(-1)
+		s.completed.Add(1)
 	}
+	s.submit(task.job)
+
+	return task
 }
@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
@@ -7,6 +9,7 @@ const (
 	// IDENTEFIERS +  LITERALS
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
 	// Operators
@@ -16,12 +19,24 @@ const (
 	MINUS   = "-"
 	ASTERIK = "*"
 	SLASH   = "/"
+	PERCENT = "%"
+	POWER   = "**"
 	GT      = ">"
 	LT      = "<"
 	EQ      = "=="
 	NEQ     = "!="
+	LTE     = "<="
+	GTE     = ">="
+	AND     = "&&"
+	OR      = "||"
+
+	PLUS_ASSIGN    = "+="
+	MINUS_ASSIGN   = "-="
+	ASTERIK_ASSIGN = "*="
+	SLASH_ASSIGN   = "/="
 	// Delimters
 	COMMA     = ","
+	DOT       = "."
 	COLON     = ":"
 	SEMICOLON = ";"
 	LPAREN    = "("
@@ -42,8 +57,19 @@ const (
 	TRUE      = "true"
 	FALSE     = "false"
 	LET       = "LET"
+	CONST     = "CONST"
 	ASYNC     = "ASYNC"
 	GENERATOR = "GENERATOR"
+	IMPORT    = "IMPORT"
+	MACRO     = "MACRO"
+	SELECT    = "SELECT"
+	CASE      = "CASE"
+	DEFAULT   = "DEFAULT"
+	FOR       = "FOR"
+	IN        = "IN"
+	WHILE     = "WHILE"
+	BREAK     = "BREAK"
+	CONTINUE  = "CONTINUE"
 )
 
 type (
@@ -51,21 +77,53 @@ type (
 	Token     struct {
 		Type    TokenType
 		Literal string
+		Pos     Position
 	}
 )
 
+// Position is where a token starts in the source,
+// Line and Column are 1-based, a zero Line means unknown.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return p.File
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RET,
-	"false":  FALSE,
-	"true":   TRUE,
-	"async":  ASYNC,
-	"await":  AWAIT,
-	"gen":    GENERATOR,
-	"yield":  YIELD,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"const":    CONST,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RET,
+	"false":    FALSE,
+	"true":     TRUE,
+	"async":    ASYNC,
+	"await":    AWAIT,
+	"gen":      GENERATOR,
+	"yield":    YIELD,
+	"import":   IMPORT,
+	"macro":    MACRO,
+	"select":   SELECT,
+	"case":     CASE,
+	"default":  DEFAULT,
+	"for":      FOR,
+	"in":       IN,
+	"while":    WHILE,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 func LookupIdent(ident string) TokenType {
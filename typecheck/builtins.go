@@ -0,0 +1,35 @@
+package typecheck
+
+// builtins are the types of the evaluator builtins,
+// the ones missing here are treated as ANY
+var builtins = map[string]*Scheme{
+	"len":   {Type: &Func{Params: []Type{ANY}, Ret: INTEGER}},
+	"sleep": {Type: &Func{Params: []Type{INTEGER}, Ret: NULL}},
+	"now":   {Type: &Func{Params: []Type{}, Ret: INTEGER}},
+	"int":   {Type: &Func{Params: []Type{ANY}, Ret: INTEGER}},
+	"float": {Type: &Func{Params: []Type{ANY}, Ret: FLOAT}},
+	// null at the end of stdin
+	"input":  {Type: &Func{Params: []Type{}, Ret: ANY}},
+	"cancel": generic(func(a *Var) Type { return &Func{Params: []Type{&Task{Value: a}}, Ret: NULL} }),
+	"awaitAll": generic(func(a *Var) Type {
+		return &Func{Params: []Type{&Array{Elem: &Task{Value: a}}}, Ret: &Array{Elem: a}}
+	}),
+	"awaitAny": generic(func(a *Var) Type { return &Func{Params: []Type{&Array{Elem: &Task{Value: a}}}, Ret: a} }),
+	"race":     generic(func(a *Var) Type { return &Func{Params: []Type{&Array{Elem: &Task{Value: a}}}, Ret: a} }),
+	"first":    generic(func(a *Var) Type { return &Func{Params: []Type{&Array{Elem: a}}, Ret: a} }),
+	"last":     generic(func(a *Var) Type { return &Func{Params: []Type{&Array{Elem: a}}, Ret: a} }),
+	"tail": generic(func(a *Var) Type {
+		return &Func{Params: []Type{&Array{Elem: a}}, Ret: &Array{Elem: a}}
+	}),
+	"push": generic(func(a *Var) Type {
+		return &Func{Params: []Type{&Array{Elem: a}, a}, Ret: &Array{Elem: a}}
+	}),
+	"freeze":   generic(func(a *Var) Type { return &Func{Params: []Type{a}, Ret: a} }),
+	"isFrozen": {Type: &Func{Params: []Type{ANY}, Ret: BOOLEAN}},
+}
+
+// generic builds a scheme quantified over one variable
+func generic(build func(a *Var) Type) *Scheme {
+	a := &Var{}
+	return &Scheme{Vars: []*Var{a}, Type: build(a)}
+}
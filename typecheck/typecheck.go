@@ -0,0 +1,722 @@
+package typecheck
+
+import (
+	"fmt"
+	"strings"
+
+	"bariq/ast"
+	"bariq/token"
+)
+
+// Checker infers the types of a program Hindley–Milner style,
+// `let` bound functions are generalized so they can be used with
+// different types. code that can't be typed statically gets ANY
+// instead of an error, the checker only reports what would
+// certainly fail at runtime.
+type Checker struct {
+	errors []string
+	nextID int
+	// bindings done by unify, used to undo a failed unification
+	trail []*Var
+	// return types of the functions being checked, innermost last
+	rets []Type
+	// yield types of the functions being checked, nil if not a generator
+	yields []Type
+}
+
+type scope struct {
+	vars map[string]*Scheme
+	// the statements declaring the const bindings of vars
+	consts map[string]*ast.LetStmt
+	outer  *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{
+		vars:   make(map[string]*Scheme),
+		consts: make(map[string]*ast.LetStmt),
+		outer:  outer,
+	}
+}
+
+func (s *scope) lookup(name string) (*Scheme, bool) {
+	for ; s != nil; s = s.outer {
+		if sc, ok := s.vars[name]; ok {
+			return sc, true
+		}
+	}
+	return nil, false
+}
+
+// Check reports the type errors found in program,
+// each prefixed with the position it was found at
+func Check(program *ast.Program) []string {
+	c := &Checker{}
+	c.inferStmts(program.Stmts, newScope(nil))
+	return c.errors
+}
+
+func (c *Checker) errorf(pos token.Position, format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	if pos.IsValid() {
+		msg = pos.String() + ": " + msg
+	}
+	c.errors = append(c.errors, msg)
+}
+
+func (c *Checker) newVar() *Var {
+	c.nextID++
+	return &Var{id: c.nextID}
+}
+
+func (c *Checker) inferStmts(stmts []ast.Stmt, s *scope) Type {
+	var res Type = NULL
+	for _, stmt := range stmts {
+		res = c.inferStmt(stmt, s)
+	}
+	return res
+}
+
+// inferBranch infers stmts that may not run, the names they bind to
+// a value of another type get ANY since the code after them may see
+// either value
+func (c *Checker) inferBranch(stmts []ast.Stmt, s *scope) Type {
+	before := make(map[string]*Scheme, len(s.vars))
+	for name, sc := range s.vars {
+		before[name] = sc
+	}
+	t := c.inferStmts(stmts, s)
+	for name, sc := range s.vars {
+		old, ok := before[name]
+		if !ok {
+			old, ok = s.outer.lookup(name)
+		}
+		if ok && old != sc && !same(old.Type, sc.Type) {
+			s.vars[name] = &Scheme{Type: ANY}
+		}
+	}
+	return t
+}
+
+func (c *Checker) inferStmt(stmt ast.Stmt, s *scope) Type {
+	switch stmt := stmt.(type) {
+	case *ast.ExprStmt:
+		return c.infer(stmt.Expr, s)
+	case *ast.LetStmt:
+		c.inferLet(stmt, s)
+		return NULL
+	case *ast.ReturnStmt:
+		t := c.infer(stmt.Value, s)
+		if len(c.rets) > 0 {
+			c.unify(c.rets[len(c.rets)-1], t)
+		}
+		return t
+	}
+	return ANY
+}
+
+// bind gives the type t to a name bound by a loop or a select case
+func (c *Checker) bind(name *ast.Ident, t Type, s *scope) {
+	if _, ok := s.consts[name.Value]; ok {
+		c.errorf(name.Pos(), "cannot assign to const %s", name.Value)
+		return
+	}
+	s.vars[name.Value] = &Scheme{Type: t}
+}
+
+func (c *Checker) inferLet(stmt *ast.LetStmt, s *scope) {
+	if decl, ok := s.consts[stmt.Name.Value]; ok && decl != stmt {
+		c.errorf(stmt.Pos(), "cannot redeclare const %s", stmt.Name.Value)
+	}
+	if stmt.Const {
+		s.consts[stmt.Name.Value] = stmt
+	} else {
+		delete(s.consts, stmt.Name.Value)
+	}
+	var t Type
+	if _, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		// let the function call itself
+		self := c.newVar()
+		s.vars[stmt.Name.Value] = &Scheme{Type: self}
+		t = c.infer(stmt.Value, s)
+		c.unify(self, t)
+		delete(s.vars, stmt.Name.Value)
+	} else {
+		t = c.infer(stmt.Value, s)
+	}
+	s.vars[stmt.Name.Value] = c.generalize(t, s)
+}
+
+func (c *Checker) infer(node ast.Expr, s *scope) Type {
+	switch node := node.(type) {
+	case *ast.IntLiteral:
+		return INTEGER
+	case *ast.FloatLiteral:
+		return FLOAT
+	case *ast.StringLiteral:
+		return STRING
+	case *ast.Boolean:
+		return BOOLEAN
+	case *ast.Ident:
+		if sc, ok := s.lookup(node.Value); ok {
+			return c.instantiate(sc)
+		}
+		if sc, ok := builtins[node.Value]; ok {
+			return c.instantiate(sc)
+		}
+		// unknown names are reported when evaluated
+		return ANY
+	case *ast.ArrayLiteral:
+		var elem Type = c.newVar()
+		for _, el := range node.Elmnts {
+			if !c.unify(elem, c.infer(el, s)) {
+				elem = ANY
+			}
+		}
+		return &Array{Elem: elem}
+	case *ast.HashLiteral:
+		return c.inferHashLiteral(node, s)
+	case *ast.PrefixExpr:
+		return c.inferPrefixExpr(node, s)
+	case *ast.InfixExpr:
+		return c.inferInfixExpr(node, s)
+	case *ast.AssignExpr:
+		return c.inferAssignExpr(node, s)
+	case *ast.IfExpr:
+		c.infer(node.Condition, s)
+		// blocks share the scope of the enclosing function
+		cons := c.inferBranch(node.Consequence.Stmts, s)
+		if node.Alternative == nil {
+			return ANY
+		}
+		alt := c.inferBranch(node.Alternative.Stmts, s)
+		if !c.unify(cons, alt) {
+			return ANY
+		}
+		return cons
+	case *ast.FunctionLiteral:
+		return c.inferFunctionLiteral(node, s)
+	case *ast.CallExpr:
+		return c.inferCallExpr(node, s)
+	case *ast.IndexExpr:
+		return c.inferIndexExpr(node, s)
+	case *ast.AwaitExpr:
+		t := prune(c.infer(node.Arg, s))
+		switch t := t.(type) {
+		case *Task:
+			return t.Value
+		case *Var:
+			return ANY
+		}
+		return t
+	case *ast.ForInExpr:
+		c.infer(node.Iterable, s)
+		if node.Key != nil {
+			c.bind(node.Key, ANY, s)
+		}
+		c.bind(node.Name, ANY, s)
+		c.inferBranch(node.Body.Stmts, s)
+		return NULL
+	case *ast.WhileExpr:
+		c.infer(node.Condition, s)
+		c.inferBranch(node.Body.Stmts, s)
+		return NULL
+	case *ast.SelectExpr:
+		// channels hold any value
+		for _, sc := range node.Cases {
+			for _, arg := range sc.Args {
+				c.infer(arg, s)
+			}
+			if sc.Value != nil {
+				c.bind(sc.Value, ANY, s)
+			}
+			if sc.Ok != nil {
+				c.bind(sc.Ok, BOOLEAN, s)
+			}
+			c.inferBranch(sc.Body.Stmts, s)
+		}
+		if node.Default != nil {
+			c.inferBranch(node.Default.Stmts, s)
+		}
+		return ANY
+	case *ast.YieldExpr:
+		t := c.infer(node.Arg, s)
+		if len(c.yields) > 0 && c.yields[len(c.yields)-1] != nil {
+			c.unify(c.yields[len(c.yields)-1], t)
+		}
+		return ANY
+	}
+	// imports, macros and quoted code are dynamic
+	return ANY
+}
+
+func (c *Checker) inferHashLiteral(node *ast.HashLiteral, s *scope) Type {
+	var key, val Type = c.newVar(), c.newVar()
+	for k, v := range node.Pairs {
+		kt := c.infer(k, s)
+		switch prune(kt).(type) {
+		case *Array, *Hash, *Func:
+			c.errorf(k.Pos(), "unusable as hash key: %s", prune(kt))
+		}
+		if !c.unify(key, kt) {
+			key = ANY
+		}
+		if !c.unify(val, c.infer(v, s)) {
+			val = ANY
+		}
+	}
+	return &Hash{Key: key, Value: val}
+}
+
+func (c *Checker) inferPrefixExpr(node *ast.PrefixExpr, s *scope) Type {
+	right := c.infer(node.Right, s)
+	switch node.Operator {
+	case "!":
+		return BOOLEAN
+	case "-":
+		if !c.unify(right, INTEGER) {
+			c.errorf(node.Pos(), "unknown operator: -%s", prune(right))
+			return INTEGER
+		}
+		return number(right, right)
+	}
+	return ANY
+}
+
+func (c *Checker) inferInfixExpr(node *ast.InfixExpr, s *scope) Type {
+	left := c.infer(node.Left, s)
+	right := c.infer(node.Right, s)
+	switch node.Operator {
+	case "==", "!=":
+		// anything can be compared
+		return BOOLEAN
+	case "+":
+		if !c.unify(left, right) {
+			c.mismatch(node, left, right)
+			return ANY
+		}
+		switch t := prune(left); t {
+		case INTEGER, FLOAT:
+			return number(left, right)
+		case STRING, ANY:
+			return t
+		default:
+			if _, ok := t.(*Var); ok {
+				return t
+			}
+			c.errorf(node.Pos(), "unknown operator: %s + %s", t, t)
+			return ANY
+		}
+	case "<", ">", "<=", ">=":
+		// numbers and strings can be ordered, an operand
+		// of unknown type is left for its uses to decide
+		if !c.unify(left, right) {
+			c.mismatch(node, left, right)
+		} else if !ordered(prune(left)) {
+			c.errorf(node.Pos(), "unknown operator: %s %s %s", prune(left), node.Operator, prune(right))
+		}
+		return BOOLEAN
+	case "-", "*", "/", "%", "**":
+		if !c.unify(left, right) {
+			c.mismatch(node, left, right)
+		} else if !c.unify(left, INTEGER) {
+			c.errorf(node.Pos(), "unknown operator: %s %s %s", prune(left), node.Operator, prune(right))
+		}
+		if node.Operator == "**" {
+			return power(left, right, node.Right)
+		}
+		return number(left, right)
+	case "&&", "||":
+		// the result is either operand, so it's only known
+		// when both have the same type
+		if l := prune(left); l == prune(right) {
+			return l
+		}
+		return ANY
+	}
+	return ANY
+}
+
+// ordered tells if the values of t can be compared with <
+func ordered(t Type) bool {
+	switch t {
+	case INTEGER, FLOAT, STRING, ANY:
+		return true
+	}
+	_, ok := t.(*Var)
+	return ok
+}
+
+// number is the type of arithmetic on left and right, a float
+// if one of them is, integers and floats unify with each other
+func number(left, right Type) Type {
+	switch {
+	case prune(left) == FLOAT || prune(right) == FLOAT:
+		return FLOAT
+	case prune(left) == ANY || prune(right) == ANY:
+		return ANY
+	}
+	return INTEGER
+}
+
+// power is the type of left ** exp, integers raised to a negative
+// power give floats, so it's only an integer for a literal exponent
+func power(left, right Type, exp ast.Expr) Type {
+	t := number(left, right)
+	if t != INTEGER {
+		return t
+	}
+	if _, ok := exp.(*ast.IntLiteral); ok {
+		return INTEGER
+	}
+	return ANY
+}
+
+func (c *Checker) inferAssignExpr(node *ast.AssignExpr, s *scope) Type {
+	var t, target Type
+	if node.Operator == "=" {
+		t = c.infer(node.Value, s)
+		target = c.infer(node.Target, s)
+	} else {
+		t = c.inferInfixExpr(&ast.InfixExpr{
+			Token:    node.Token,
+			Left:     node.Target,
+			Operator: strings.TrimSuffix(node.Operator, "="),
+			Right:    node.Value,
+		}, s)
+		// the infix expression already reported its errors
+		n := len(c.errors)
+		target = c.infer(node.Target, s)
+		c.errors = c.errors[:n]
+	}
+	name, owner := assigned(node.Target, s)
+	if owner == nil {
+		return t
+	}
+	if _, ok := owner.consts[name]; ok {
+		c.errorf(node.Pos(), "cannot assign to const %s", name)
+		return t
+	}
+	// a name can be given a value of another type, it is left
+	// dynamic then since its uses may see either type
+	if !c.unify(target, t) {
+		owner.vars[name] = &Scheme{Type: ANY}
+	}
+	return t
+}
+
+// assigned is the name target assigns to and the scope binding
+// it, the scope is nil for an unknown name
+func assigned(target ast.Expr, s *scope) (string, *scope) {
+	for {
+		index, ok := target.(*ast.IndexExpr)
+		if !ok {
+			break
+		}
+		target = index.Left
+	}
+	name, ok := target.(*ast.Ident)
+	if !ok {
+		return "", nil
+	}
+	for ; s != nil; s = s.outer {
+		if _, ok := s.vars[name.Value]; ok {
+			return name.Value, s
+		}
+	}
+	return name.Value, nil
+}
+
+func (c *Checker) mismatch(node *ast.InfixExpr, left, right Type) {
+	c.errorf(node.Pos(), "type mismatch: %s %s %s", prune(left), node.Operator, prune(right))
+}
+
+func (c *Checker) inferFunctionLiteral(node *ast.FunctionLiteral, s *scope) Type {
+	fs := newScope(s)
+	params := make([]Type, len(node.Parameters))
+	for i, p := range node.Parameters {
+		v := c.newVar()
+		params[i] = v
+		fs.vars[p.Value] = &Scheme{Type: v}
+	}
+	var ret Type = c.newVar()
+	var yield Type
+	if node.Gen {
+		yield = c.newVar()
+	}
+	c.rets = append(c.rets, ret)
+	c.yields = append(c.yields, yield)
+	body := c.inferStmts(node.Body.Stmts, fs)
+	c.rets = c.rets[:len(c.rets)-1]
+	c.yields = c.yields[:len(c.yields)-1]
+	if !c.unify(ret, body) {
+		// returns values of different types
+		ret = ANY
+	}
+	if node.Gen {
+		ret = &Gen{Value: yield}
+	}
+	if node.Async {
+		ret = &Task{Value: ret}
+	}
+	return &Func{Params: params, Ret: ret}
+}
+
+func (c *Checker) inferCallExpr(node *ast.CallExpr, s *scope) Type {
+	if ident, ok := node.Function.(*ast.Ident); ok && ident.Value == "quote" {
+		return ANY
+	}
+	fn := prune(c.infer(node.Function, s))
+	args := make([]Type, len(node.Args))
+	for i, a := range node.Args {
+		args[i] = c.infer(a, s)
+	}
+	switch fn := fn.(type) {
+	case *Func:
+		// extra args are ignored like by the evaluator
+		if len(args) < len(fn.Params) {
+			c.errorf(
+				node.Pos(),
+				"wrong number of args for %s, got %d, want %d",
+				node.Function, len(args), len(fn.Params),
+			)
+			return fn.Ret
+		}
+		for i, a := range args[:len(fn.Params)] {
+			if !c.unify(fn.Params[i], a) {
+				c.errorf(
+					node.Args[i].Pos(),
+					"cannot use %s as %s in argument %d of %s",
+					prune(a), prune(fn.Params[i]), i+1, node.Function,
+				)
+			}
+		}
+		return fn.Ret
+	case *Var:
+		ret := c.newVar()
+		c.unify(fn, &Func{Params: args, Ret: ret})
+		return ret
+	}
+	if fn != ANY {
+		c.errorf(node.Pos(), "not a function: %s", fn)
+	}
+	return ANY
+}
+
+func (c *Checker) inferIndexExpr(node *ast.IndexExpr, s *scope) Type {
+	left := prune(c.infer(node.Left, s))
+	index := c.infer(node.Index, s)
+	switch left := left.(type) {
+	case *Array:
+		if !c.unify(index, INTEGER) {
+			c.errorf(node.Pos(), "index operator not supported: %s[%s]", left, prune(index))
+		}
+		return left.Elem
+	case *Hash:
+		c.unify(left.Key, index)
+		return left.Value
+	case *Var:
+		// could be an array, a hash or a module
+		return ANY
+	}
+	if left != ANY {
+		c.errorf(node.Pos(), "index operator not supported: %s", left)
+	}
+	return ANY
+}
+
+// unify makes a and b the same type by binding variables, on failure
+// the bindings it did are undone
+func (c *Checker) unify(a, b Type) bool {
+	mark := len(c.trail)
+	if c.unifyRec(a, b) {
+		return true
+	}
+	for _, v := range c.trail[mark:] {
+		v.instance = nil
+	}
+	c.trail = c.trail[:mark]
+	return false
+}
+
+func (c *Checker) unifyRec(a, b Type) bool {
+	a, b = prune(a), prune(b)
+	if a == ANY || b == ANY {
+		return true
+	}
+	if v, ok := a.(*Var); ok {
+		if v == b || occurs(v, b) {
+			// recursive types are left dynamic
+			return true
+		}
+		v.instance = b
+		c.trail = append(c.trail, v)
+		return true
+	}
+	if _, ok := b.(*Var); ok {
+		return c.unifyRec(b, a)
+	}
+	switch a := a.(type) {
+	case Basic:
+		// integers are converted to floats when mixed with them
+		return a == b || numeric(a) && numeric(b)
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && c.unifyRec(a.Elem, b.Elem)
+	case *Hash:
+		b, ok := b.(*Hash)
+		return ok && c.unifyRec(a.Key, b.Key) && c.unifyRec(a.Value, b.Value)
+	case *Task:
+		b, ok := b.(*Task)
+		return ok && c.unifyRec(a.Value, b.Value)
+	case *Gen:
+		b, ok := b.(*Gen)
+		return ok && c.unifyRec(a.Value, b.Value)
+	case *Func:
+		b, ok := b.(*Func)
+		if !ok || len(a.Params) != len(b.Params) {
+			return false
+		}
+		for i := range a.Params {
+			if !c.unifyRec(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return c.unifyRec(a.Ret, b.Ret)
+	}
+	return false
+}
+
+func numeric(t Type) bool { return t == INTEGER || t == FLOAT }
+
+// same tells if a and b are the same type without binding variables
+func same(a, b Type) bool {
+	a, b = prune(a), prune(b)
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && same(a.Elem, b.Elem)
+	case *Hash:
+		b, ok := b.(*Hash)
+		return ok && same(a.Key, b.Key) && same(a.Value, b.Value)
+	case *Task:
+		b, ok := b.(*Task)
+		return ok && same(a.Value, b.Value)
+	case *Gen:
+		b, ok := b.(*Gen)
+		return ok && same(a.Value, b.Value)
+	case *Func:
+		b, ok := b.(*Func)
+		if !ok || len(a.Params) != len(b.Params) {
+			return false
+		}
+		for i := range a.Params {
+			if !same(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return same(a.Ret, b.Ret)
+	}
+	return false
+}
+
+func occurs(v *Var, t Type) bool {
+	found := false
+	walk(t, func(tv *Var) {
+		if tv == v {
+			found = true
+		}
+	})
+	return found
+}
+
+// walk calls fn on every unbound variable of t
+func walk(t Type, fn func(*Var)) {
+	switch t := prune(t).(type) {
+	case *Var:
+		fn(t)
+	case *Array:
+		walk(t.Elem, fn)
+	case *Hash:
+		walk(t.Key, fn)
+		walk(t.Value, fn)
+	case *Task:
+		walk(t.Value, fn)
+	case *Gen:
+		walk(t.Value, fn)
+	case *Func:
+		for _, p := range t.Params {
+			walk(p, fn)
+		}
+		walk(t.Ret, fn)
+	}
+}
+
+// generalize quantifies the variables of t that are not used
+// by the bindings in s, so each use of the binding can pick its own types
+func (c *Checker) generalize(t Type, s *scope) *Scheme {
+	inScope := map[*Var]bool{}
+	for sc := s; sc != nil; sc = sc.outer {
+		for _, scheme := range sc.vars {
+			bound := map[*Var]bool{}
+			for _, v := range scheme.Vars {
+				bound[v] = true
+			}
+			walk(scheme.Type, func(v *Var) {
+				if !bound[v] {
+					inScope[v] = true
+				}
+			})
+		}
+	}
+	scheme := &Scheme{Type: t}
+	seen := map[*Var]bool{}
+	walk(t, func(v *Var) {
+		if !inScope[v] && !seen[v] {
+			seen[v] = true
+			scheme.Vars = append(scheme.Vars, v)
+		}
+	})
+	return scheme
+}
+
+// instantiate replaces the quantified variables of sc with fresh ones
+func (c *Checker) instantiate(sc *Scheme) Type {
+	if len(sc.Vars) == 0 {
+		return sc.Type
+	}
+	fresh := make(map[*Var]Type, len(sc.Vars))
+	for _, v := range sc.Vars {
+		fresh[v] = c.newVar()
+	}
+	return substitute(sc.Type, fresh)
+}
+
+func substitute(t Type, fresh map[*Var]Type) Type {
+	switch t := prune(t).(type) {
+	case *Var:
+		if f, ok := fresh[t]; ok {
+			return f
+		}
+		return t
+	case *Array:
+		return &Array{Elem: substitute(t.Elem, fresh)}
+	case *Hash:
+		return &Hash{Key: substitute(t.Key, fresh), Value: substitute(t.Value, fresh)}
+	case *Task:
+		return &Task{Value: substitute(t.Value, fresh)}
+	case *Gen:
+		return &Gen{Value: substitute(t.Value, fresh)}
+	case *Func:
+		params := make([]Type, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = substitute(p, fresh)
+		}
+		return &Func{Params: params, Ret: substitute(t.Ret, fresh)}
+	default:
+		return t
+	}
+}
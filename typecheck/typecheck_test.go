@@ -0,0 +1,145 @@
+package typecheck
+
+import (
+	"testing"
+
+	"bariq/lexer"
+	"bariq/parser"
+)
+
+func testCheck(t *testing.T, input string) []string {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return Check(program)
+}
+
+func TestWellTypedPrograms(t *testing.T) {
+	tests := []string{
+		`1 + 2 * 3`,
+		`"a" + "b"`,
+		`let x = 5; let y = x * 2; y < 10`,
+		`let id = fn(x) { x }; id(1) + 2; id("a") + "b"`,
+		`let add = fn(a, b) { a + b }; add(1, 2); add("a", "b")`,
+		`let fact = fn(n) { if (n < 2) { return 1; } n * fact(n - 1) }; fact(5) + 1`,
+		`let arr = [1, 2, 3]; first(arr) + last(arr) + len(arr)`,
+		`let mixed = [1, "two", true]; mixed[0]`,
+		`let h = {"a": 1, "b": 2}; h["a"] + 1`,
+		`1 == "a"; true != 2`,
+		`let f = fn(x) { if (x) { 1 } else { "one" } }; f(true)`,
+		`let s = async fn(x) { x * 2 }; await(s(1)) + 1`,
+		`let g = fn gen () { yield 1; yield 2; }; next(g())`,
+		`let apply = fn(f, x) { f(x) }; apply(fn(y) { y + 1 }, 1)`,
+		`puts(1, "a", [1]); unknown + 1`,
+		`let compose = fn(f, g) { fn(x) { f(g(x)) } }; compose(len, tail)([1, 2]) + 1`,
+		`let c = chan(1); select { case v, ok = recv(c) { if (ok) { v } } default { 0 } }`,
+		`let tasks = [async fn() { 1 }()]; awaitAll(tasks)[0] + race(tasks)`,
+		`let x = 1; x += 2; x = "a"; x + "b"`,
+		`let half = fn(x) { x / 2 }; half(3) + half(1.5) + float(1) * int("2")`,
+		`2 ** 64 % 7 + 2 ** -1`,
+		`let a = [1, 2]; a[0] = 3; a[1] *= 2`,
+		`const x = 1; let f = fn() { let x = 2; x = 3 }; let y = 0; while (y < 2) { const z = y; y += 1 }`,
+		`let between = fn(x) { x >= 0 && x <= 10 }; if (between(5) || "a" < "b") { 1 }`,
+		`let name = [][0] || "anon"; (true && 1) + (1 || 2)`,
+		`let f = fn(x) { x + 1 }; f(1, "extra")`,
+		`let lt = fn(a, b) { a < b }; lt("a", "b"); lt(1, 2); lt(1.5, 2)`,
+		`let x = 1; if (false) { let x = "a"; }; x + 1`,
+		`let x = 1; let f = fn(c) { while (c) { let x = "a"; c = false }; x + 1 }`,
+		`let n = 2; 2 ** n + 0.5; 2 ** 2 % 3`,
+	}
+	for _, input := range tests {
+		if errs := testCheck(t, input); len(errs) != 0 {
+			t.Errorf("unexpected type errors for %q: %v", input, errs)
+		}
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`5 + "a"`, "1:3: type mismatch: INTEGER + STRING"},
+		{`let x = 5;\nlet y = "s";\nx + y`, "3:3: type mismatch: INTEGER + STRING"},
+		{`true + false`, "1:6: unknown operator: BOOLEAN + BOOLEAN"},
+		{`"a" - "b"`, "1:5: unknown operator: STRING - STRING"},
+		{`-"a"`, "1:1: unknown operator: -STRING"},
+		{`let f = fn(x) { x * 2 }; f("a")`, `1:28: cannot use STRING as INTEGER in argument 1 of f`},
+		{`let f = fn(x, y) { x }; f(1)`, "1:26: wrong number of args for f, got 1, want 2"},
+		{`let x = 1; x(2)`, "1:13: not a function: INTEGER"},
+		{`let f = fn(x) { x + 1 }; f(2) + "a"`, "1:31: type mismatch: INTEGER + STRING"},
+		{`let arr = [1, 2]; arr["a"]`, `1:22: index operator not supported: ARRAY<INTEGER>[STRING]`},
+		{`let arr = [1, 2]; first(arr) + "a"`, "1:30: type mismatch: INTEGER + STRING"},
+		{`{[1]: 1}`, "1:2: unusable as hash key: ARRAY<INTEGER>"},
+		{`let s = async fn() { 1 }; await(s()) + "a"`, "1:38: type mismatch: INTEGER + STRING"},
+		{`let x = 1; x += "a"`, `1:14: type mismatch: INTEGER + STRING`},
+		{`let a = [1]; a["k"] = 1`, `1:15: index operator not supported: ARRAY<INTEGER>[STRING]`},
+		{`const x = 1; let f = fn() { x += 1 }`, "1:31: cannot assign to const x"},
+		{`const x = 1;\nlet x = 2`, "2:1: cannot redeclare const x"},
+		{`const x = 1; for (x in [5, 6]) {}`, "1:19: cannot assign to const x"},
+		{`const ok = 1; let c = chan(1); select { case v, ok = recv(c) { v } }`, "1:49: cannot assign to const ok"},
+		{`let avg = 1.5 * 2; avg + "a"`, "1:24: type mismatch: FLOAT + STRING"},
+		{`"a" % 2`, "1:5: type mismatch: STRING % INTEGER"},
+		{`"a" <= 1`, "1:5: type mismatch: STRING <= INTEGER"},
+		{`true >= false`, "1:6: unknown operator: BOOLEAN >= BOOLEAN"},
+		{`[1] < [2]`, "1:5: unknown operator: ARRAY<INTEGER> < ARRAY<INTEGER>"},
+		{`(1 && 2) + "a"`, "1:10: type mismatch: INTEGER + STRING"},
+		{`let fn_ = fn(f) { f(1) + f("a") }`, `1:28: cannot use STRING as INTEGER in argument 1 of f`},
+		{`let x = 1; if (true) { let x = "a"; x + 1 }`, "1:39: type mismatch: STRING + INTEGER"},
+		{`let x = 1; if (true) { let x = 2 }; x + "a"`, "1:39: type mismatch: INTEGER + STRING"},
+	}
+	for _, tt := range tests {
+		input := tt.input
+		errs := testCheck(t, replaceNewlines(input))
+		if len(errs) == 0 {
+			t.Errorf("expected type error for %q", input)
+			continue
+		}
+		if errs[0] != tt.expected {
+			t.Errorf("wrong error for %q, expected %q, got %q", input, tt.expected, errs[0])
+		}
+	}
+}
+
+func TestInferredTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`2 ** 3`, "INTEGER"},
+		{`2 ** -1`, "ANY"},
+		{`let n = 3; 2 ** n`, "ANY"},
+		{`2.5 ** 2`, "FLOAT"},
+		{`let x = 1; if (true) { let x = 2 }; x`, "INTEGER"},
+		{`let x = 1; if (false) { let x = "a" }; x`, "ANY"},
+		{`let x = [1]; for (i in [1]) { let x = ["a"] }; x`, "ANY"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		c := &Checker{}
+		s := newScope(nil)
+		c.inferStmts(program.Stmts[:len(program.Stmts)-1], s)
+		last := program.Stmts[len(program.Stmts)-1]
+		if got := prune(c.inferStmt(last, s)).String(); got != tt.expected {
+			t.Errorf("wrong type for %q, expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func replaceNewlines(s string) string {
+	out := []byte{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == 'n' {
+			out = append(out, '\n')
+			i++
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
@@ -0,0 +1,101 @@
+package typecheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type is the static type of an expression, the names of the
+// basic types match the object types reported at runtime
+type Type interface {
+	String() string
+}
+
+type Basic string
+
+func (b Basic) String() string { return string(b) }
+
+const (
+	INTEGER Basic = "INTEGER"
+	FLOAT   Basic = "FLOAT"
+	STRING  Basic = "STRING"
+	BOOLEAN Basic = "BOOLEAN"
+	NULL    Basic = "NULL"
+	// ANY is used where a type can't be known statically,
+	// it matches every other type
+	ANY Basic = "ANY"
+)
+
+type Array struct {
+	Elem Type
+}
+
+func (a *Array) String() string { return "ARRAY<" + prune(a.Elem).String() + ">" }
+
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) String() string {
+	return "HASH<" + prune(h.Key).String() + ", " + prune(h.Value).String() + ">"
+}
+
+type Func struct {
+	Params []Type
+	Ret    Type
+}
+
+func (f *Func) String() string {
+	params := []string{}
+	for _, p := range f.Params {
+		params = append(params, prune(p).String())
+	}
+	return "fn(" + strings.Join(params, ", ") + ") " + prune(f.Ret).String()
+}
+
+// Task is what calling an async function returns
+type Task struct {
+	Value Type
+}
+
+func (t *Task) String() string { return "TASK<" + prune(t.Value).String() + ">" }
+
+// Gen is what calling a generator function returns
+type Gen struct {
+	Value Type
+}
+
+func (g *Gen) String() string { return "GEN<" + prune(g.Value).String() + ">" }
+
+// Var is a type not inferred yet, once bound
+// to another type it stands for that type
+type Var struct {
+	id       int
+	instance Type
+}
+
+func (v *Var) String() string {
+	if v.instance != nil {
+		return prune(v).String()
+	}
+	return fmt.Sprintf("t%d", v.id)
+}
+
+// prune follows bound variables to the type they stand for
+func prune(t Type) Type {
+	for {
+		v, ok := t.(*Var)
+		if !ok || v.instance == nil {
+			return t
+		}
+		t = v.instance
+	}
+}
+
+// Scheme is a type generalized over some of its variables,
+// ex: the type of `fn(x) { x }` is forall t1. fn(t1) t1
+type Scheme struct {
+	Vars []*Var
+	Type Type
+}
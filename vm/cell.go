@@ -0,0 +1,20 @@
+package vm
+
+import "bariq/object"
+
+// cell holds a variable captured by a closure, the slot of the
+// variable and the closures capturing it share the cell
+type cell struct {
+	val object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+func (c *cell) Inspect() string         { return "<cell>" }
+
+// deref is the value of obj, or the value it holds if it is a cell
+func deref(obj object.Object) object.Object {
+	if c, ok := obj.(*cell); ok {
+		return c.val
+	}
+	return obj
+}
@@ -0,0 +1,24 @@
+package vm
+
+import (
+	"bariq/code"
+	"bariq/object"
+)
+
+// Frame is the state of a function call
+type Frame struct {
+	cl *object.Closure
+	// offset of the next instruction to run
+	ip int
+	// offset of the instruction being run
+	ins         int
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"bariq/evaluator"
+	"bariq/object"
+)
+
+// iterator is the state of a for loop, it stays on the
+// stack below the values of the loop body
+type iterator struct {
+	keys, vals []object.Object
+	// the loop binds both the key and the value
+	pairs bool
+	next  int
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "<iterator>" }
+
+// newIterator iterates over obj the way the evaluator does,
+// a loop with a single name gets the keys of a hash
+func newIterator(obj object.Object, names int) (*iterator, *object.Error) {
+	it := &iterator{pairs: names == 2}
+	switch obj := obj.(type) {
+	case *object.Array:
+		for i, el := range obj.Elements {
+			it.keys = append(it.keys, &object.Integer{Value: int64(i)})
+			it.vals = append(it.vals, el)
+		}
+	case *object.String:
+		for i, r := range []rune(obj.Value) {
+			it.keys = append(it.keys, &object.Integer{Value: int64(i)})
+			it.vals = append(it.vals, &object.String{Value: string(r)})
+		}
+	case *object.Hash:
+		for _, pair := range evaluator.SortedPairs(obj) {
+			it.keys = append(it.keys, pair.Key)
+			if it.pairs {
+				it.vals = append(it.vals, pair.Value)
+			} else {
+				it.vals = append(it.vals, pair.Key)
+			}
+		}
+	default:
+		return nil, evaluator.NewError("cannot iterate over %s", obj.Type())
+	}
+	return it, nil
+}
@@ -0,0 +1,562 @@
+package vm
+
+import (
+	"bariq/code"
+	"bariq/compiler"
+	"bariq/evaluator"
+	"bariq/object"
+)
+
+const (
+	// initial sizes, the stack and the frames grow with the calls
+	// up to the depth limit of the context
+	StackSize   = 2048
+	FramesSize  = 1024
+	GlobalsSize = 65536
+)
+
+type VM struct {
+	constants []object.Object
+	globals   []object.Object
+	// names of the global slots, for errors
+	globalNames []string
+	// resolved in the builtins of ctx, nil when missing
+	builtins     []*object.Builtin
+	builtinNames []string
+
+	stack []object.Object
+	// always points to the next free slot,
+	// the top of the stack is stack[sp-1]
+	sp int
+
+	frames      []*Frame
+	framesIndex int
+
+	// value of the last top level expression statement
+	result object.Object
+
+	// passed to the builtins
+	ctx *object.Context
+}
+
+func New(bytecode *compiler.Bytecode, ctx *object.Context) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+		CallNames:    bytecode.CallNames,
+	}
+	frames := make([]*Frame, FramesSize)
+	frames[0] = NewFrame(&object.Closure{Fn: mainFn}, 0)
+	builtins := make([]*object.Builtin, len(bytecode.Builtins))
+	for i, name := range bytecode.Builtins {
+		builtins[i], _ = evaluator.LookupBuiltin(name, ctx)
+	}
+	return &VM{
+		builtins:     builtins,
+		builtinNames: bytecode.Builtins,
+		constants:    bytecode.Constants,
+		globals:      make([]object.Object, GlobalsSize),
+		globalNames:  bytecode.Globals,
+		stack:        make([]object.Object, StackSize),
+		frames:       frames,
+		framesIndex:  1,
+		ctx:          ctx,
+	}
+}
+
+func (vm *VM) currentFrame() *Frame { return vm.frames[vm.framesIndex-1] }
+
+// pushFrame runs f, the depth of the calls is
+// limited by the context, not by the vm
+func (vm *VM) pushFrame(f *Frame) {
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// Run runs the bytecode returning the value of the last
+// top level expression, or an *object.Error if it failed
+func (vm *VM) Run() object.Object {
+	err := vm.run()
+	if err != nil {
+		vm.trace(err)
+	}
+	// the calls an error unwound through
+	for ; vm.framesIndex > 1; vm.framesIndex-- {
+		vm.ctx.Leave()
+	}
+	if err != nil {
+		return err
+	}
+	return vm.result
+}
+
+func (vm *VM) run() *object.Error {
+	for vm.framesIndex > 0 {
+		frame := vm.currentFrame()
+		ins := frame.Instructions()
+		if frame.ip >= len(ins) {
+			// only the main function runs off its end
+			return nil
+		}
+		frame.ins = frame.ip
+		op := code.Opcode(ins[frame.ip])
+		frame.ip++
+		// like the evaluator counts nodes
+		if err := vm.ctx.Step(); err != nil {
+			return err
+		}
+
+		switch op {
+		case code.OpConstant:
+			idx := code.ReadUint16(ins[frame.ip:])
+			frame.ip += 2
+			if err := vm.push(vm.constants[idx]); err != nil {
+				return err
+			}
+		case code.OpPop:
+			val := vm.pop()
+			if vm.framesIndex == 1 {
+				vm.result = val
+			}
+		case code.OpTrue:
+			if err := vm.push(evaluator.TRUE); err != nil {
+				return err
+			}
+		case code.OpFalse:
+			if err := vm.push(evaluator.FALSE); err != nil {
+				return err
+			}
+		case code.OpNull:
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+			code.OpMod, code.OpPow, code.OpLessEqual, code.OpGreaterEqual:
+			left := vm.pop()
+			right := vm.pop()
+			res := evaluator.Infix(infixOperators[op], left, right)
+			if err := vm.pushResult(evaluator.Alloc(vm.ctx, res)); err != nil {
+				return err
+			}
+		case code.OpMinus, code.OpBang:
+			right := vm.pop()
+			if err := vm.pushResult(evaluator.Prefix(prefixOperators[op], right)); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			frame.ip = int(code.ReadUint16(ins[frame.ip:]))
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[frame.ip:]))
+			frame.ip += 2
+			if !evaluator.IsTruthy(vm.pop()) {
+				frame.ip = pos
+			}
+		case code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop:
+			pos := int(code.ReadUint16(ins[frame.ip:]))
+			frame.ip += 2
+			if evaluator.IsTruthy(vm.stack[vm.sp-1]) == (op == code.OpJumpTruthyOrPop) {
+				frame.ip = pos
+			} else {
+				vm.pop()
+			}
+
+		case code.OpSetGlobal:
+			idx := code.ReadUint16(ins[frame.ip:])
+			frame.ip += 2
+			vm.globals[idx] = vm.pop()
+			if vm.framesIndex == 1 {
+				vm.result = nil
+			}
+		case code.OpGetGlobal:
+			idx := code.ReadUint16(ins[frame.ip:])
+			frame.ip += 2
+			val := vm.globals[idx]
+			if val == nil {
+				return evaluator.NewError("ident not found: " + vm.globalNames[idx])
+			}
+			if err := vm.push(val); err != nil {
+				return err
+			}
+		case code.OpSetLocal:
+			idx := code.ReadUint8(ins[frame.ip:])
+			frame.ip++
+			slot := &vm.stack[frame.basePointer+int(idx)]
+			if c, ok := (*slot).(*cell); ok {
+				c.val = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
+		case code.OpGetLocal:
+			idx := code.ReadUint8(ins[frame.ip:])
+			frame.ip++
+			val := deref(vm.stack[frame.basePointer+int(idx)])
+			if val == nil {
+				// its let didn't run yet
+				return evaluator.NewError("ident not found: " + frame.cl.Fn.LocalNames[idx])
+			}
+			if err := vm.push(val); err != nil {
+				return err
+			}
+		case code.OpGetLocalCell:
+			idx := code.ReadUint8(ins[frame.ip:])
+			frame.ip++
+			slot := &vm.stack[frame.basePointer+int(idx)]
+			if _, ok := (*slot).(*cell); !ok {
+				*slot = &cell{val: *slot}
+			}
+			if err := vm.push(*slot); err != nil {
+				return err
+			}
+		case code.OpGetBuiltin:
+			idx := code.ReadUint16(ins[frame.ip:])
+			frame.ip += 2
+			builtin := vm.builtins[idx]
+			if builtin == nil {
+				// compiled for other builtins than those of ctx
+				return evaluator.NewError("ident not found: " + vm.builtinNames[idx])
+			}
+			if err := vm.push(builtin); err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			idx := code.ReadUint8(ins[frame.ip:])
+			frame.ip++
+			val := deref(frame.cl.Free[idx])
+			if val == nil {
+				return evaluator.NewError("ident not found: " + frame.cl.Fn.FreeNames[idx])
+			}
+			if err := vm.push(val); err != nil {
+				return err
+			}
+		case code.OpGetFreeCell:
+			idx := code.ReadUint8(ins[frame.ip:])
+			frame.ip++
+			if err := vm.push(frame.cl.Free[idx]); err != nil {
+				return err
+			}
+		case code.OpAssign:
+			idx := code.ReadUint16(ins[frame.ip:])
+			frame.ip += 2
+			if err := vm.assign(frame, vm.constants[idx].(*compiler.Assignment)); err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			if err := vm.push(frame.cl); err != nil {
+				return err
+			}
+
+		case code.OpArray:
+			n := int(code.ReadUint16(ins[frame.ip:]))
+			frame.ip += 2
+			elements := make([]object.Object, n)
+			copy(elements, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			if err := vm.pushResult(evaluator.Alloc(vm.ctx, &object.Array{Elements: elements})); err != nil {
+				return err
+			}
+		case code.OpHash:
+			n := int(code.ReadUint16(ins[frame.ip:]))
+			frame.ip += 2
+			hash, err := vm.buildHash(vm.sp-n, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp -= n
+			if err := vm.pushResult(evaluator.Alloc(vm.ctx, hash)); err != nil {
+				return err
+			}
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.pushResult(evaluator.Index(left, index)); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			if err := vm.call(numArgs); err != nil {
+				return err
+			}
+		case code.OpReturnValue:
+			val := vm.pop()
+			if vm.framesIndex == 1 {
+				// a top level return ends the program
+				vm.result = val
+				return nil
+			}
+			f := vm.popFrame()
+			vm.ctx.Leave()
+			vm.sp = f.basePointer - 1
+			if err := vm.push(val); err != nil {
+				return err
+			}
+		case code.OpReturn:
+			f := vm.popFrame()
+			vm.ctx.Leave()
+			vm.sp = f.basePointer - 1
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+		case code.OpClosure:
+			idx := code.ReadUint16(ins[frame.ip:])
+			numFree := int(code.ReadUint8(ins[frame.ip+2:]))
+			frame.ip += 3
+			fn := vm.constants[idx].(*object.CompiledFunction)
+			free := make([]object.Object, numFree)
+			copy(free, vm.stack[vm.sp-numFree:vm.sp])
+			vm.sp -= numFree
+			if err := vm.ctx.Alloc(evaluator.EnvSize(numFree)); err != nil {
+				return err
+			}
+			if err := vm.push(&object.Closure{Fn: fn, Free: free}); err != nil {
+				return err
+			}
+
+		case code.OpIter:
+			names := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			it, err := newIterator(vm.pop(), names)
+			if err != nil {
+				return err
+			}
+			if err := vm.push(it); err != nil {
+				return err
+			}
+		case code.OpNext:
+			pos := int(code.ReadUint16(ins[frame.ip:]))
+			frame.ip += 2
+			it := vm.stack[vm.sp-1].(*iterator)
+			if it.next == len(it.vals) {
+				frame.ip = pos
+				continue
+			}
+			if it.pairs {
+				if err := vm.push(it.keys[it.next]); err != nil {
+					return err
+				}
+			}
+			if err := vm.push(it.vals[it.next]); err != nil {
+				return err
+			}
+			it.next++
+		}
+	}
+	return nil
+}
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+}
+
+var prefixOperators = map[code.Opcode]string{
+	code.OpMinus: "-",
+	code.OpBang:  "!",
+}
+
+func (vm *VM) call(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		if numArgs < callee.Fn.NumParameters {
+			return evaluator.NewError(
+				"wrong number of args for %s, got %d, want %d",
+				vm.callName(vm.currentFrame(), callee),
+				numArgs, callee.Fn.NumParameters,
+			)
+		}
+		// extra args are ignored like by the evaluator
+		basePointer := vm.sp - numArgs
+		if err := vm.ctx.Alloc(evaluator.EnvSize(callee.Fn.NumLocals)); err != nil {
+			return err
+		}
+		if err := vm.ctx.Enter(); err != nil {
+			return err
+		}
+		vm.pushFrame(NewFrame(callee, basePointer))
+		vm.grow(basePointer + callee.Fn.NumLocals)
+		// the locals not set yet are unbound, extra args included
+		unset := vm.stack[basePointer+callee.Fn.NumParameters : basePointer+callee.Fn.NumLocals]
+		for i := range unset {
+			unset[i] = nil
+		}
+		vm.sp = basePointer + callee.Fn.NumLocals
+		return nil
+	case *object.Builtin:
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		res := callee.Fn(vm.ctx, args...)
+		if err, ok := res.(*object.Error); ok {
+			return err
+		}
+		if res == nil {
+			res = evaluator.NULL
+		}
+		vm.sp -= numArgs + 1
+		return vm.push(res)
+	default:
+		return evaluator.NewError("not a function: %s", callee.Type())
+	}
+}
+
+// assign runs a, replacing the value and the indexes
+// on the stack by the value assigned
+func (vm *VM) assign(frame *Frame, a *compiler.Assignment) *object.Error {
+	// the innermost index is on top
+	indexes := make([]object.Object, a.Indexes)
+	for i := range indexes {
+		indexes[i] = vm.pop()
+	}
+	val := vm.pop()
+	var slot *object.Object
+	switch a.Symbol.Scope {
+	case compiler.GlobalScope:
+		slot = &vm.globals[a.Symbol.Index]
+	case compiler.LocalScope:
+		slot = &vm.stack[frame.basePointer+a.Symbol.Index]
+	case compiler.FreeScope:
+		slot = &frame.cl.Free[a.Symbol.Index]
+	}
+	if slot != nil {
+		if c, ok := (*slot).(*cell); ok {
+			slot = &c.val
+		}
+	}
+	last := a.Positions[len(a.Positions)-1]
+	switch {
+	case slot == nil || *slot == nil:
+		err := evaluator.NewError("cannot assign to undeclared %s", a.Symbol.Name)
+		err.Pos = a.Positions[0]
+		return err
+	case a.Symbol.Const:
+		err := evaluator.NewError("cannot assign to const %s", a.Symbol.Name)
+		err.Pos = last
+		return err
+	}
+	var res object.Object
+	updated := evaluator.Assign(vm.ctx, *slot, indexes, a.Positions[1:], func(old object.Object) object.Object {
+		res = val
+		if a.Operator != "" {
+			res = evaluator.Alloc(vm.ctx, evaluator.Infix(a.Operator, old, val))
+		}
+		return res
+	})
+	if err, ok := updated.(*object.Error); ok {
+		if !err.Pos.IsValid() {
+			err.Pos = last
+		}
+		return err
+	}
+	*slot = updated
+	return vm.push(res)
+}
+
+func (vm *VM) buildHash(start, end int) (object.Object, *object.Error) {
+	pairs := make(map[object.HashKey]object.HashPair)
+	for i := start; i < end; i += 2 {
+		key := vm.stack[i]
+		val := vm.stack[i+1]
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, evaluator.NewError("unusable as hashKey: %s", key.Type())
+		}
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: val}
+	}
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+// pushResult pushes the result of an operation,
+// stopping the vm if it is an error
+func (vm *VM) pushResult(obj object.Object) *object.Error {
+	if err, ok := obj.(*object.Error); ok {
+		return err
+	}
+	return vm.push(obj)
+}
+
+func (vm *VM) push(obj object.Object) *object.Error {
+	vm.grow(vm.sp + 1)
+	vm.stack[vm.sp] = obj
+	vm.sp++
+	return nil
+}
+
+// grow makes room for size values on the stack
+func (vm *VM) grow(size int) {
+	if size <= len(vm.stack) {
+		return
+	}
+	n := 2 * len(vm.stack)
+	if n < size {
+		n = size
+	}
+	stack := make([]object.Object, n)
+	copy(stack, vm.stack)
+	vm.stack = stack
+}
+
+func (vm *VM) pop() object.Object {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+// trace sets the position and the stack of err from the
+// active frames, the same way the evaluator does it
+func (vm *VM) trace(err *object.Error) {
+	frame := vm.currentFrame()
+	if !err.Pos.IsValid() {
+		err.Pos = frame.cl.Fn.Positions[frame.ins]
+	}
+	ins := frame.Instructions()
+	if code.Opcode(ins[frame.ins]) == code.OpCall {
+		// the call itself failed, ex: calling a builtin
+		numArgs := int(code.ReadUint8(ins[frame.ins+1:]))
+		callee := vm.stack[vm.sp-1-numArgs]
+		err.Stack = append(err.Stack, object.Frame{
+			Name: vm.callName(frame, callee),
+			Pos:  frame.cl.Fn.Positions[frame.ins],
+		})
+	}
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		caller := vm.frames[i-1]
+		err.Stack = append(err.Stack, object.Frame{
+			Name: vm.callName(caller, vm.frames[i].cl),
+			Pos:  caller.cl.Fn.Positions[caller.ins],
+		})
+	}
+}
+
+// callName is the name shown in stack traces for
+// the call to callee being run by caller
+func (vm *VM) callName(caller *Frame, callee object.Object) string {
+	if cl, ok := callee.(*object.Closure); ok && cl.Fn.Name != "" {
+		return cl.Fn.Name
+	}
+	if name, ok := caller.cl.Fn.CallNames[caller.ins]; ok {
+		return name
+	}
+	return "<anonymous>"
+}
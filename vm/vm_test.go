@@ -0,0 +1,296 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"bariq/ast"
+	"bariq/compiler"
+	"bariq/evaluator"
+	"bariq/lexer"
+	"bariq/object"
+	"bariq/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func runVM(t *testing.T, input string) object.Object {
+	c := compiler.New()
+	if err := c.Compile(parse(t, input)); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+	return New(c.Bytecode(), object.NewContext()).Run()
+}
+
+// the same programs are run by both backends,
+// their results must be the same
+var sharedTests = []string{
+	"1",
+	"1 + 2 * 3 - 4 / 2",
+	"-5 + 10",
+	"!true",
+	"!!5",
+	"1 < 2 == true",
+	"1 > 2 != false",
+	`"foo" + "bar"`,
+	`"a" == "a"`,
+	"if (1 > 2) { 10 }",
+	"if (1 < 2) { 10 } else { 20 }",
+	"if (false) { 10 } else { 20 }",
+	"let a = 5; let b = a * 2; a + b",
+	"let a = 1; let a = a + 1; a",
+	"[1, 2 + 3, 4 * 5]",
+	"[1, 2, 3][1]",
+	"[1, 2, 3][5]",
+	`{"a": 1, 2: "b", true: 3}["a"]`,
+	`{"a": 1}["b"]`,
+	"let add = fn(a, b) { a + b }; add(1, 2)",
+	"let f = fn() { return 1; 2 }; f()",
+	"let f = fn() { if (true) { return 5 }; 10 }; f()",
+	"let f = fn() { }; f()",
+	"let f = fn(x) { x }; f(1, 2)",
+	"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+	"let adder = fn(a) { fn(b) { a + b } }; let addTwo = adder(2); addTwo(3)",
+	"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)",
+	"let f = fn() { g() }; let g = fn() { 7 }; f()",
+	"let x = 10; let f = fn() { let x = 1; x }; f() + x",
+	`len("four") + len([1, 2])`,
+	"first([7, 8]) + last([7, 8])",
+	"tail([1, 2, 3])",
+	"push([1], 2)",
+	"return 3; 4",
+	"fn(x) { x * 2 }(4)",
+	"let avg = fn(a, b) { (a + b) / 2.0 }; avg(1, 2.5)",
+	"[1 == 1.0, -1.5 < 1, int(2.5), float(2)]",
+	"[9223372036854775807 + 1, 2 ** 70 % 1000, -7 % 3, 2 ** -2]",
+	"let h = {18446744073709551616: 1}; h[2 ** 64]",
+	`["a" < "b", "b" <= "a", "ab" >= "a", 2 >= 2.0, 2 ** 64 <= 1]`,
+	`[1 && 2, 0 && 2, [][0] && 2, false || "x", 1 || 2, true && [][0]]`,
+	"false && 1 / 0",
+	"let f = fn(n) { n > 0 && (n == 1 || f(n - 1)) }; f(5)",
+	"let i = 0; while (i < 5) { let i = i + 1 }; i",
+	"let i = 0; while (true) { let i = i + 1; if (i == 3) { break } }; i",
+	"while (false) { 1 }",
+	"let out = []; for (x in [1, 2, 3, 4]) { if (x == 2) { continue }; let out = push(out, x) }; out",
+	`let out = []; for (i, x in ["a", "b"]) { let out = push(out, [i, x]) }; out`,
+	`let out = []; for (c in "héy") { let out = push(out, c) }; out`,
+	`let out = []; for (k in {"b": 2, "a": 1, 3: 0}) { let out = push(out, k) }; out`,
+	`let out = []; for (k, v in {3: "c", 1: "a", 2: "b"}) { let out = push(out, [k, v]) }; out`,
+	"let find = fn(xs) { for (x in xs) { if (x > 1) { return x } }; 0 }; [find([1, 5, 7]), find([])]",
+	"let out = []; for (x in [1, 2]) { for (y in [1, 2]) { if (y == 2) { break }; let out = push(out, [x, y]) } }; out",
+	"let f = fn() { let n = 0; for (x in [1, 2, 3]) { let n = n + x }; n }; f()",
+	"let n = 0; for (i in [1, 2, 3]) { let n = n + (if (i == 2) { break } else { i }) }; n",
+	"let out = []; for (i in [1, 2, 3]) { let out = push(out, if (i == 2) { continue } else { i }) }; out",
+	"let f = fn() { let i = 0; while (i < 3) { let i = i + 1; [1, 2, if (i < 3) { continue } else { 0 }] }; i }; f()",
+	"for (x in [1]) { x }",
+	// the values left by a continue don't pile up on the stack
+	"let i = 0; while (i < 5000) { let i = i + 1; [1, if (true) { continue }] }; i",
+	"for (x in 5) {}",
+	"for (x in [1]) { x + true }",
+	"while (1 + true) {}",
+	"let x = 1; x = 2; x",
+	"let x = 1; let y = 2; x = y = 7; [x, y]",
+	"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x",
+	"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()",
+	"let f = fn() { let x = 1; let g = fn() { x = 2 }; g(); x }; f()",
+	"let f = fn() { let x = 1; let g = fn() { fn() { x += 10 } }; g()(); let h = fn() { x }; x = x * 2; h() }; f()",
+	"let f = fn(n) { let fs = []; for (i in [1, 2, 3]) { fs = push(fs, fn() { i + n }) }; n = 10; [fs[0](), fs[2]()] }; f(0)",
+	"let f = fn() { let x = 1; let g = fn() { x }; let x = 5; g() }; f()",
+	"let a = [1, [2, 3]]; a[1][0] = 9; a[0] *= 10; a",
+	"let a = [1, 2]; let b = a; a[0] = 5; [a, b]",
+	`let h = {"x": 1}; h["y"] = 2; h["x"] -= 5; [h["x"], h["y"]]`,
+	"let f = fn() { let a = [[0]]; let g = fn() { a[0][0] += 1 }; g(); g(); a }; f()",
+	"let f = fn(n) { if (n > 0) { f = 0; n } else { 0 } }; [f(1), f]",
+	"let i = 0; let n = 0; while ((i += 1) < 5) { n += i }; n",
+	// closures see the names bound after them when they are called
+	"let f = fn() { let h = fn() { y }; let y = 2; h() }; f()",
+	"let x = 1; let f = fn() { let g = fn() { x }; let x = 2; g() }; f()",
+	"let f = fn() { let a = x; let x = 2; a }; let x = 1; f()",
+	"let f = fn() { let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(10) }; f()",
+	"let f = fn() { let g = fn() { fn() { z } }; let z = 3; g()() }; f()",
+	"let f = fn() { let g = fn() { y }; let a = y; let y = 2; [a, g()] }; let y = 1; f()",
+	"let f = fn() { let h = fn() { y }; h(); let y = 2 }; f()",
+	"let f = fn() { if (false) { let y = 1 }; y }; f()",
+	// calls as deep as the depth limit allows
+	"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(2000)",
+	"let f = fn(n) { f(n + 1) }; f(0)",
+	// errors
+	"x = 1",
+	"let f = fn() { y += 1 }; f()",
+	"len = 1",
+	"let x = 1; x += true",
+	"let a = [1]; a[3] = 1",
+	"let a = [[1]]; a[0][3] += 1",
+	`let s = "ab"; s[0] = "c"`,
+	"let a = [1, 2]; a[0][1] = 3",
+	"let h = {}; h[[1]] = 1",
+	"const x = 1; x = 2",
+	"const x = 1; let f = fn() { x += 1 }; f()",
+	"let a = freeze([1]); a[0] = 2",
+
+	// errors
+	"5 + true",
+	"-true",
+	"foobar",
+	`"a" - "b"`,
+	"1(2)",
+	`len(1)`,
+	"[1][true]",
+	"let f = fn(x) { x + true }; f(1)",
+	`{"a": 1}[fn(x) { x }]`,
+	`1.5 * "a"`,
+	"let f = fn(x) { 1 / x }; f(0)",
+	"10 % 0",
+}
+
+func TestSharedSuite(t *testing.T) {
+	for _, input := range sharedTests {
+		want := evaluator.Eval(parse(t, input), object.NewEnv(), object.NewContext())
+		if want == nil {
+			// the evaluator gives nil for empty blocks
+			want = evaluator.NULL
+		}
+		got := runVM(t, input)
+		if wantErr, ok := want.(*object.Error); ok {
+			gotErr, ok := got.(*object.Error)
+			if !ok {
+				t.Errorf("%q: expected error %q, got %T (%+v)", input, wantErr.Message, got, got)
+				continue
+			}
+			if gotErr.Inspect() != wantErr.Inspect() {
+				t.Errorf("%q: wrong error, want %q, got %q", input, wantErr.Inspect(), gotErr.Inspect())
+			}
+			continue
+		}
+		if got == nil || got.Inspect() != want.Inspect() {
+			t.Errorf("%q: wrong result, want %s, got %+v", input, want.Inspect(), got)
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `
+	let inner = fn(x) { x + true };
+	let outer = fn(y) { inner(y) };
+	let wrap = fn() { outer(1) };
+	wrap();
+	`
+	want := evaluator.Eval(parse(t, input), object.NewEnv(), object.NewContext()).(*object.Error)
+	got, ok := runVM(t, input).(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+	if got.Traceback() != want.Traceback() {
+		t.Errorf("wrong traceback.\nwant\n%s\ngot\n%s", want.Traceback(), got.Traceback())
+	}
+}
+
+func TestDepthLimit(t *testing.T) {
+	got := runVM(t, "let f = fn(n) { f(n + 1) }; f(0)")
+	err, ok := got.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned, got %T (%+v)", got, got)
+	}
+	if !errors.Is(err, object.ErrDepthLimit) {
+		t.Errorf("wrong error, got %q", err.Message)
+	}
+}
+
+func TestWrongNumberOfArgs(t *testing.T) {
+	got := runVM(t, "let f = fn(a, b) { a }; f(1)")
+	err, ok := got.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned, got %T (%+v)", got, got)
+	}
+	if err.Message != "wrong number of args for f, got 1, want 2" {
+		t.Errorf("wrong error message, got %q", err.Message)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		ctx   *object.Context
+		input string
+		want  error
+	}{
+		{&object.Context{Limits: object.Limits{MaxSteps: 1000}}, "let f = fn(n) { if (n < 2) { n } else { f(n - 1) + f(n - 2) } }; f(20)", object.ErrStepLimit},
+		{&object.Context{Limits: object.Limits{MaxDepth: 10}}, "let f = fn(n) { f(n + 1) }; f(0)", object.ErrDepthLimit},
+		{&object.Context{Limits: object.Limits{MaxAlloc: 1000}}, `let f = fn(a, n) { if (n == 0) { a } else { f([a, a], n - 1) } }; f([], 100)`, object.ErrAllocLimit},
+		{&object.Context{Limits: object.Limits{MaxAlloc: 1000}}, `let f = fn(s, n) { if (n == 0) { s } else { f(s + "xxxxxxxx", n - 1) } }; f("", 1000)`, object.ErrAllocLimit},
+		{&object.Context{Ctx: expired}, "let f = fn(n) { f(n) }; f(0)", context.Canceled},
+	}
+	for _, tt := range tests {
+		c := compiler.New()
+		if err := c.Compile(parse(t, tt.input)); err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+		err, ok := New(c.Bytecode(), tt.ctx).Run().(*object.Error)
+		if !ok {
+			t.Errorf("%q: expected an error", tt.input)
+			continue
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%q: want %v, got %q", tt.input, tt.want, err.Message)
+		}
+	}
+
+	// the calls unwound by an error leave the depth count
+	ctx := &object.Context{Limits: object.Limits{MaxDepth: 5, MaxSteps: 200}}
+	c := compiler.New()
+	if err := c.Compile(parse(t, "let f = fn(n) { f(n + 1) }; f(0)")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	New(c.Bytecode(), ctx).Run()
+	if err := ctx.Enter(); err != nil {
+		t.Errorf("depth not restored after an error: %s", err.Message)
+	}
+}
+
+func TestContextBuiltins(t *testing.T) {
+	ctx := object.NewContext()
+	ctx.Builtins = evaluator.Builtins()
+	ctx.Builtins["double"] = &object.Builtin{Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	}}
+	delete(ctx.Builtins, "len")
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`double(4) + first([1])`, "9"},
+		{`let f = fn(x) { double(x) }; f(5)`, "10"},
+		{`len("ab")`, "ERROR: 1:1: ident not found: len"},
+	}
+	for _, tt := range tests {
+		want := evaluator.Eval(parse(t, tt.input), object.NewEnv(), ctx).Inspect()
+		if want != tt.expected {
+			t.Errorf("%q: the evaluator gave %q, want %q", tt.input, want, tt.expected)
+		}
+		c := compiler.NewWithBuiltins(ctx.Builtins)
+		if err := c.Compile(parse(t, tt.input)); err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+		if got := New(c.Bytecode(), ctx).Run().Inspect(); got != tt.expected {
+			t.Errorf("%q: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+
+	// bytecode compiled for builtins ctx doesn't have
+	c := compiler.NewWithBuiltins(ctx.Builtins)
+	if err := c.Compile(parse(t, `double(1)`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if got := New(c.Bytecode(), object.NewContext()).Run().Inspect(); got != "ERROR: 1:1: ident not found: double" {
+		t.Errorf("wrong result for a missing builtin, got %q", got)
+	}
+}